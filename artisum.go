@@ -2,122 +2,456 @@ package artisum
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"slices"
+	"sync"
 	"time"
 
 	"golang.org/x/sync/errgroup"
 )
 
-const (
-	configFilePath = ".artisum.json"
-)
-
 type Artisum struct {
-	feeder          *Feeder
-	extracter       *Extracter
-	formatter       *ArticleFormatter
-	notionRepo      *NotionRepository
-	fileRepo        *FileRepository
+	feeder           *Feeder
+	prefilter        *Prefilter
+	ranker           *Ranker
+	extracter        *Extracter
+	formatter        *ArticleFormatter
+	sinks            []*Sink
+	fileRepo         *FileRepository
+	ledger           *Ledger
+	runMu            sync.Mutex
+	failureThreshold float64
+	// offline runs read the cached feeds, so they leave the execute time, the ledger and the feed states as they are.
+	offline         bool
+	promptVersion   string
 	now             time.Time
 	lastExecuteTime time.Time
 }
 
 type SummaryArticle struct {
-	Origin   *InterestArticle
-	Contents []*FormatContent
+	Origin   *InterestArticle `json:"origin"`
+	Contents []*FormatContent `json:"contents"`
+	// PromptVersion is the version of the prompts the contents were generated with.
+	PromptVersion string `json:"promptVersion,omitempty"`
+	// Locations are where the sinks saved the article so far, e.g. the URL of the Notion page, by sink name.
+	Locations map[string]string `json:"locations,omitempty"`
 }
 
 func NewArtisum(
+	ctx context.Context,
+	conf *Config,
 	numOfSummary int,
-	modelName string,
 	now time.Time,
-	notionRepo *NotionRepository,
+	sinks []*Sink,
 	fileRepo *FileRepository,
 ) (*Artisum, error) {
-	conf, err := LoadConfig(configFilePath)
+	lastExecuteTime, err := fileRepo.GetLatestExecuteTime()
 	if err != nil {
 		return nil, err
 	}
 
-	lastExecuteTime, err := fileRepo.GetLatestExecuteTime()
+	if lastExecuteTime.IsZero() {
+		lastExecuteTime = now.AddDate(0, 0, -3)
+	}
+
+	ledger, err := fileRepo.GetLedger()
 	if err != nil {
 		return nil, err
 	}
 
-	if lastExecuteTime.IsZero() {
-		lastExecuteTime = now.AddDate(0, 0, -3)
+	feedStates, err := fileRepo.GetFeedStates()
+	if err != nil {
+		return nil, err
+	}
+
+	fetcher := NewFetcher(conf.Fetcher)
+	feeder := NewFeeder(conf.Feeds, conf.FeedFetch, fetcher, ledger, feedStates, fileRepo, lastExecuteTime, now)
+
+	selectLLM, err := NewLLM(ctx, conf.LLM.Select, NewArtisumLogHandler("興味対象から記事抽出"))
+	if err != nil {
+		return nil, err
+	}
+	summarizeLLM, err := NewLLM(ctx, conf.LLM.Summarize, NewArtisumLogHandler("記事のフォーマット"))
+	if err != nil {
+		return nil, err
+	}
+	var repairer *JSONRepairer
+	if conf.LLM.Repair != nil {
+		repairLLM, err := NewLLM(ctx, conf.LLM.Repair, NewArtisumLogHandler("JSONの修復"))
+		if err != nil {
+			return nil, err
+		}
+		repairer = NewJSONRepairer(repairLLM, conf.LLM.Repair)
+	}
+	promptSet, err := LoadPrompts(conf.Prompts, conf.Summary, numOfSummary, conf.Tags)
+	if err != nil {
+		return nil, err
+	}
+
+	prefilter, err := NewPrefilter(conf.Tags, conf.Feeds)
+	if err != nil {
+		return nil, err
 	}
 
-	feeder := NewFeeder(conf.Urls, lastExecuteTime, now)
+	ranker, err := NewRanker(ctx, conf.Embedding, conf.Tags, conf.Feeds)
+	if err != nil {
+		return nil, err
+	}
 
-	extracter, err := NewExtracter(modelName, numOfSummary, conf.Tags)
+	extracter, err := NewExtracter(selectLLM, conf.LLM.Select, repairer, promptSet, numOfSummary, conf.Tags, conf.Feeds)
 	if err != nil {
 		return nil, err
 	}
 
-	formatter, err := NewArticleFormatter(modelName)
+	formatter, err := NewArticleFormatter(summarizeLLM, conf.LLM.Summarize, repairer, promptSet, conf.Summary, fetcher)
 	if err != nil {
 		return nil, err
 	}
 
 	return &Artisum{
-		feeder:          feeder,
-		extracter:       extracter,
-		formatter:       formatter,
-		notionRepo:      notionRepo,
-		fileRepo:        fileRepo,
-		now:             now,
-		lastExecuteTime: lastExecuteTime,
+		feeder:           feeder,
+		prefilter:        prefilter,
+		ranker:           ranker,
+		extracter:        extracter,
+		formatter:        formatter,
+		sinks:            sinks,
+		fileRepo:         fileRepo,
+		ledger:           ledger,
+		failureThreshold: conf.FailureThreshold,
+		offline:          conf.FeedFetch.Offline,
+		promptVersion:    promptSet.Version(),
+		now:              now,
+		lastExecuteTime:  lastExecuteTime,
 	}, nil
 }
 
-func (a *Artisum) Summary(ctx context.Context) error {
+func (a *Artisum) Summary(ctx context.Context) (*RunReport, error) {
 	slog.Info("start summary", slog.String("lastExecuteTime", a.lastExecuteTime.Format(time.DateOnly)), slog.String("now", a.now.Format(time.DateOnly)))
 	if a.isExecutedToday() {
 		slog.Info("already executed today")
-		return nil
+		return nil, nil
 	}
 
-	slog.Info("start collect articles...")
-	feedArticleMap, err := a.feeder.ToArticlesMap()
+	run := NewRun(a.now)
+	slog.Info("start run", slog.String("id", run.ID))
+	return a.execute(ctx, run)
+}
+
+func (a *Artisum) Resume(ctx context.Context, runID string) (*RunReport, error) {
+	run, err := a.fileRepo.GetRun(runID)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	slog.Info("collected")
-	if len(feedArticleMap) == 0 {
+	if run.CompletedAt != nil {
+		slog.Info("run is already completed", slog.String("id", run.ID))
+		return run.Report(), nil
+	}
+
+	slog.Info("resume run", slog.String("id", run.ID))
+	return a.execute(ctx, run)
+}
+
+func (a *Artisum) execute(ctx context.Context, run *Run) (report *RunReport, err error) {
+	defer func() {
+		if a.offline {
+			return
+		}
+		if saveErr := a.fileRepo.SaveLedger(a.ledger); saveErr != nil && err == nil {
+			err = saveErr
+		}
+	}()
+
+	if run.FeedArticles == nil {
+		slog.Info("start collect articles...")
+		feedArticleMap, feedFailures, err := a.feeder.ToArticlesMap(ctx)
+		// Only the failure counts are saved here. The validators wait for completeRun.
+		if !a.offline {
+			if saveErr := a.fileRepo.SaveFeedStates(a.feeder.States()); saveErr != nil {
+				slog.Error("failed to save feed states", slog.String("error", saveErr.Error()))
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		slog.Info("collected", slog.Int("failedFeeds", len(feedFailures)))
+		// The snapshot is only a record of the feeds, so the run goes on without it.
+		if err := a.fileRepo.SaveFeedSnapshot(feedArticleMap); err != nil {
+			slog.Warn("failed to save feed snapshot", slog.String("error", err.Error()))
+		}
+		if err := a.updateRun(run, func() {
+			run.FeedArticles = feedArticleMap
+			run.FeedFailures = feedFailures
+			run.FeedValidators = a.feeder.Validators()
+		}); err != nil {
+			return nil, err
+		}
+	}
+	if !run.hasFeedArticles() {
 		slog.Info("no articles from feeds")
-		return nil
+		return run.Report(), a.completeRun(run)
 	}
 
-	slog.Info("extracting...")
-	articles, err := a.extracter.Extract(ctx, feedArticleMap)
-	if err != nil {
-		return err
+	if !run.Extracted {
+		// The run keeps every collected article, so the filters are applied again when it is resumed.
+		candidates := a.prefilter.Filter(run.FeedArticles, run.StartedAt)
+		var scores map[string]float64
+		if a.ranker != nil && len(candidates) > 0 {
+			candidates, scores, err = a.ranker.Rank(ctx, candidates)
+			if err != nil {
+				return nil, err
+			}
+		}
+		var (
+			articles []*InterestArticle
+			rejected []*RejectedArticle
+		)
+		if len(candidates) > 0 {
+			slog.Info("extracting...")
+			articles, rejected, err = a.extracter.Extract(ctx, candidates)
+			if err != nil {
+				return nil, err
+			}
+		}
+		for _, article := range articles {
+			article.EmbeddingScore = scores[article.URL]
+			slog.Info("selected article", slog.String("url", article.URL), slog.Int("score", article.Score), slog.String("reason", article.Reason))
+		}
+		slog.Info("extracted", slog.Int("selected", len(articles)), slog.Int("rejected", len(rejected)))
+		if err := a.updateRun(run, func() { run.setExtracted(articles, rejected) }); err != nil {
+			return nil, err
+		}
 	}
-	slog.Info("extracted")
 
+	// Each article is processed independently; a failure is recorded on the run instead of aborting the others.
 	var eg errgroup.Group
-	for _, article := range articles {
+	for _, runArticle := range run.Articles {
+		if runArticle.isDone() {
+			continue
+		}
 		eg.Go(func() error {
-			slog.Info("formatting...", slog.String("url", article.URL), slog.String("tag", article.Tag))
-			FormatContents, err := a.formatter.Format(ctx, article.URL)
-			if err != nil {
-				return err
+			stage, err := a.processArticle(ctx, run, runArticle)
+			if err == nil {
+				return nil
+			}
+			slog.Warn("failed to process article", slog.String("url", runArticle.Article.URL), slog.String("stage", stage), slog.String("error", err.Error()))
+			if err := a.updateRun(run, func() { runArticle.fail(stage, err) }); err != nil {
+				slog.Error("failed to save run", slog.String("error", err.Error()))
 			}
+			return nil
+		})
+	}
+	_ = eg.Wait()
+
+	// Digests are delivered once the failures are under the threshold. The articles saved when the run is resumed
+	// are delivered then, in another digest.
+	var flushErr error
+	if !run.Report().ExceedsThreshold(a.failureThreshold) {
+		flushErr = a.flush(ctx, run)
+	}
+
+	report = run.Report()
+	report.Log()
+
+	if len(report.Failures) == 0 && flushErr == nil {
+		if err := a.completeRun(run); err != nil {
+			return report, err
+		}
+	} else {
+		slog.Info("failed articles and sinks can be retried with `artisum resume <run-id>`", slog.String("id", run.ID))
+	}
+	if flushErr != nil {
+		return report, fmt.Errorf("failed to flush sinks: %w", flushErr)
+	}
+
+	if report.ExceedsThreshold(a.failureThreshold) {
+		return report, &FailureThresholdError{Report: report, Threshold: a.failureThreshold}
+	}
+	if a.offline || run.StartedAt.Before(a.lastExecuteTime) {
+		return report, nil
+	}
+	return report, a.fileRepo.SaveExecuteTime(run.StartedAt)
+}
+
+func (a *Artisum) processArticle(ctx context.Context, run *Run, runArticle *RunArticle) (string, error) {
+	article := runArticle.Article
 
-			slog.Info("formatted")
-			return a.notionRepo.SaveSummaryResult(ctx, &SummaryArticle{
-				Origin:   article,
-				Contents: FormatContents,
+	if runArticle.Status == ArticleStatusPending {
+		exists, err := a.existsArticle(ctx, article.URL)
+		if err != nil {
+			return StageCheck, err
+		}
+		if exists {
+			slog.Info("skip article already saved", slog.String("url", article.URL))
+			a.ledger.MarkSummarized(article.URL, a.now)
+			return StageCheck, a.updateRun(run, func() {
+				runArticle.Status = ArticleStatusSkipped
+				runArticle.clearError()
 			})
-		})
+		}
+
+		slog.Info("formatting...", slog.String("url", article.URL), slog.String("tag", article.Tag))
+		formatContents, err := a.formatter.Format(ctx, article.URL)
+		if err != nil {
+			return StageFormat, err
+		}
+		slog.Info("formatted")
+		if err := a.updateRun(run, func() {
+			runArticle.Status = ArticleStatusFormatted
+			runArticle.Contents = formatContents
+			runArticle.PromptVersion = a.promptVersion
+		}); err != nil {
+			return StageFormat, err
+		}
+	}
+
+	if err := a.saveArticle(ctx, run, runArticle); err != nil {
+		return StageSave, err
+	}
+	a.ledger.MarkSummarized(article.URL, a.now)
+	return StageSave, a.updateRun(run, func() {
+		runArticle.Status = ArticleStatusSaved
+		runArticle.clearError()
+	})
+}
+
+// existsArticle reports whether any sink which keeps track of its articles already has the article.
+func (a *Artisum) existsArticle(ctx context.Context, url string) (bool, error) {
+	for _, sink := range a.sinks {
+		checker, ok := sink.Store.(ArticleChecker)
+		if !ok {
+			continue
+		}
+		exists, err := checker.ExistsArticle(ctx, url)
+		if err != nil {
+			return false, fmt.Errorf("%s: %w", sink.Name, err)
+		}
+		if exists {
+			return true, nil
+		}
 	}
+	return false, nil
+}
 
-	if err := eg.Wait(); err != nil {
-		return err
+// saveArticle saves the article to the sinks in order, skipping those which saved it in a previous attempt.
+// A failure of an optional sink is only recorded, while the others fail the article so that it is retried on resume.
+func (a *Artisum) saveArticle(ctx context.Context, run *Run, runArticle *RunArticle) error {
+	article := runArticle.summaryArticle()
+	var errs []error
+	for _, sink := range a.sinks {
+		if status, ok := runArticle.Sinks[sink.Name]; ok && status.Saved {
+			continue
+		}
+		location, err := sink.Store.SaveSummaryResult(ctx, article)
+		status := &SinkStatus{Saved: err == nil, Location: location}
+		if err != nil {
+			status.Error = err.Error()
+			slog.Warn("failed to save article", slog.String("sink", sink.Name), slog.String("url", runArticle.Article.URL), slog.String("error", err.Error()))
+			if !sink.Optional {
+				errs = append(errs, fmt.Errorf("%s: %w", sink.Name, err))
+			}
+		} else if location != "" {
+			article.Locations[sink.Name] = location
+		}
+		if err := a.updateRun(run, func() { runArticle.setSinkStatus(sink.Name, status) }); err != nil {
+			return err
+		}
 	}
+	return errors.Join(errs...)
+}
 
-	return a.fileRepo.SaveExecuteTime(a.now)
+// flush delivers the digests of the articles saved in the run which the sinks have not delivered yet.
+func (a *Artisum) flush(ctx context.Context, run *Run) error {
+	articles := run.savedArticles()
+	if len(articles) == 0 {
+		return nil
+	}
+	var errs []error
+	for _, sink := range a.sinks {
+		flusher, ok := sink.Store.(Flusher)
+		if !ok {
+			continue
+		}
+		err := flusher.Flush(ctx, articles, &runFlushProgress{artisum: a, run: run, sink: sink.Name})
+		if err != nil {
+			slog.Warn("failed to flush", slog.String("sink", sink.Name), slog.String("error", err.Error()))
+			if !sink.Optional {
+				errs = append(errs, fmt.Errorf("%s: %w", sink.Name, err))
+			}
+		}
+		if err := a.updateRun(run, func() {
+			status := run.flushStatus(sink.Name)
+			status.Saved = err == nil
+			status.Error = ""
+			if err != nil {
+				status.Error = err.Error()
+			}
+		}); err != nil {
+			return err
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// runFlushProgress keeps the progress of flushing a sink in the run.
+type runFlushProgress struct {
+	artisum *Artisum
+	run     *Run
+	sink    string
+}
+
+func (p *runFlushProgress) Delivered(recipient, url string) bool {
+	p.artisum.runMu.Lock()
+	defer p.artisum.runMu.Unlock()
+
+	status, ok := p.run.Flushes[p.sink]
+	return ok && slices.Contains(status.Delivered[recipient], url)
+}
+
+func (p *runFlushProgress) MarkDelivered(recipient string, articles []*SummaryArticle) error {
+	urls := make([]string, len(articles))
+	for i, article := range articles {
+		urls[i] = article.Origin.URL
+	}
+	return p.artisum.updateRun(p.run, func() { p.run.flushStatus(p.sink).markDelivered(recipient, urls) })
+}
+
+func (p *runFlushProgress) Note(key string) string {
+	p.artisum.runMu.Lock()
+	defer p.artisum.runMu.Unlock()
+
+	if status, ok := p.run.Flushes[p.sink]; ok {
+		return status.Notes[key]
+	}
+	return ""
+}
+
+func (p *runFlushProgress) SetNotes(notes map[string]string) error {
+	return p.artisum.updateRun(p.run, func() { p.run.flushStatus(p.sink).setNotes(notes) })
+}
+
+// updateRun applies fn to run and persists it. Articles are processed concurrently, so every change goes through here.
+func (a *Artisum) updateRun(run *Run, fn func()) error {
+	a.runMu.Lock()
+	defer a.runMu.Unlock()
+
+	fn()
+	return a.fileRepo.SaveRun(run)
+}
+
+// completeRun marks the run completed and commits the feed validators it fetched.
+func (a *Artisum) completeRun(run *Run) error {
+	if !a.offline && len(run.FeedValidators) > 0 {
+		a.feeder.CommitValidators(run.FeedValidators)
+		if err := a.fileRepo.SaveFeedStates(a.feeder.States()); err != nil {
+			return err
+		}
+	}
+	return a.updateRun(run, func() {
+		completedAt := a.now
+		run.CompletedAt = &completedAt
+	})
 }
 
 func (a *Artisum) isExecutedToday() bool {
@@ -0,0 +1,105 @@
+package artisum
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+// recordingFlusher delivers each article on its own and fails on the URLs in failOn.
+type recordingFlusher struct {
+	delivered []string
+	failOn    map[string]bool
+}
+
+func (f *recordingFlusher) SaveSummaryResult(context.Context, *SummaryArticle) (string, error) {
+	return "", nil
+}
+
+func (f *recordingFlusher) Flush(_ context.Context, articles []*SummaryArticle, progress FlushProgress) error {
+	for _, article := range undelivered(articles, progress, "") {
+		if f.failOn[article.Origin.URL] {
+			return errors.New("unavailable")
+		}
+		f.delivered = append(f.delivered, article.Origin.URL)
+		if err := progress.MarkDelivered("", []*SummaryArticle{article}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestArtisumFlush(t *testing.T) {
+	now := time.Date(2024, 8, 13, 9, 0, 0, 0, time.UTC)
+	flusher := &recordingFlusher{failOn: map[string]bool{"https://example.com/b": true}}
+	a := &Artisum{
+		sinks:    []*Sink{{Name: "digest", Store: flusher}},
+		fileRepo: NewFileRepository(t.TempDir(), "model", "ja", now),
+	}
+	run := NewRun(now)
+	run.setExtracted([]*InterestArticle{
+		{Title: "a", URL: "https://example.com/a"},
+		{Title: "b", URL: "https://example.com/b"},
+		{Title: "c", URL: "https://example.com/c"},
+	}, nil)
+	run.Articles[0].Status = ArticleStatusSaved
+	run.Articles[1].Status = ArticleStatusSaved
+	run.Articles[2].fail(StageFormat, errors.New("timeout"))
+
+	if err := a.flush(context.Background(), run); err == nil {
+		t.Fatal("the failure of the flusher is not returned")
+	}
+	status := run.Flushes["digest"]
+	if status.Saved || status.Error == "" || !slices.Equal(status.Delivered[""], []string{"https://example.com/a"}) {
+		t.Errorf("status = %+v", status)
+	}
+
+	// The resumed run delivers the rest, including the article saved on resume, and nothing twice.
+	flusher.failOn = nil
+	run.Articles[2].Status = ArticleStatusSaved
+	if err := a.flush(context.Background(), run); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"https://example.com/a", "https://example.com/b", "https://example.com/c"}; !slices.Equal(flusher.delivered, want) {
+		t.Errorf("delivered %q, want %q", flusher.delivered, want)
+	}
+	if status := run.Flushes["digest"]; !status.Saved || status.Error != "" || len(status.Delivered[""]) != 3 {
+		t.Errorf("status = %+v", status)
+	}
+
+	// The progress is persisted with the run.
+	saved, err := a.fileRepo.GetRun(run.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := saved.Flushes["digest"].Delivered[""]; len(got) != 3 {
+		t.Errorf("saved delivered = %q", got)
+	}
+	if err := a.flush(context.Background(), run); err != nil || len(flusher.delivered) != 3 {
+		t.Errorf("flushed again: %q, %v", flusher.delivered, err)
+	}
+}
+
+func TestRunFlushProgressNotes(t *testing.T) {
+	now := time.Date(2024, 8, 13, 9, 0, 0, 0, time.UTC)
+	a := &Artisum{fileRepo: NewFileRepository(t.TempDir(), "model", "ja", now)}
+	run := NewRun(now)
+	progress := &runFlushProgress{artisum: a, run: run, sink: "slack"}
+	if got := progress.Note("thread:https://example.com/a"); got != "" {
+		t.Errorf("note = %q before it is set", got)
+	}
+	if err := progress.SetNotes(map[string]string{"thread:https://example.com/a": "1.000"}); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := a.fileRepo.GetRun(run.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resumed := &runFlushProgress{artisum: a, run: saved, sink: "slack"}
+	if got := resumed.Note("thread:https://example.com/a"); got != "1.000" {
+		t.Errorf("note = %q after resume, want 1.000", got)
+	}
+}
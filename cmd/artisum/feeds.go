@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/kazdevl/artisum"
+)
+
+const feedsUsage = "usage: artisum feeds add <url>... | import <opml file> | export [opml file] | list"
+
+func runFeeds(args []string) error {
+	if len(args) == 0 {
+		return errors.New(feedsUsage)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	switch args[0] {
+	case "add":
+		if len(args) < 2 {
+			return errors.New(feedsUsage)
+		}
+		return addFeeds(ctx, args[1:])
+	case "import":
+		if len(args) != 2 {
+			return errors.New(feedsUsage)
+		}
+		return importFeeds(args[1])
+	case "export":
+		if len(args) > 2 {
+			return errors.New(feedsUsage)
+		}
+		var path string
+		if len(args) == 2 {
+			path = args[1]
+		}
+		return exportFeeds(path)
+	case "list":
+		return listFeeds()
+	default:
+		return errors.New(feedsUsage)
+	}
+}
+
+func addFeeds(ctx context.Context, siteURLs []string) error {
+	conf, err := artisum.LoadConfig(configPathF)
+	if err != nil {
+		return err
+	}
+	fetcher := artisum.NewFetcher(conf.Fetcher)
+
+	var feeds []*artisum.FeedConfig
+	for _, siteURL := range siteURLs {
+		discovered, err := artisum.DiscoverFeeds(ctx, fetcher, siteURL)
+		if err != nil {
+			return fmt.Errorf("failed to discover feeds of %s: %w", siteURL, err)
+		}
+		if len(discovered) > 1 {
+			slog.Info("multiple feeds are found. the first one is added", slog.String("url", siteURL), slog.Any("feeds", discovered))
+		}
+		feeds = append(feeds, &artisum.FeedConfig{URL: discovered[0]})
+	}
+
+	return saveFeeds(feeds)
+}
+
+func importFeeds(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	feeds, err := artisum.ImportOPML(f)
+	if err != nil {
+		return err
+	}
+
+	return saveFeeds(feeds)
+}
+
+func saveFeeds(feeds []*artisum.FeedConfig) error {
+	return artisum.UpdateConfig(configPathF, func(c *artisum.Config) error {
+		added := c.AddFeeds(feeds...)
+		for _, f := range added {
+			fmt.Println("added:", f.URL)
+		}
+		if len(added) == 0 {
+			fmt.Println("no new feed")
+		}
+		return nil
+	})
+}
+
+func exportFeeds(path string) error {
+	conf, err := artisum.LoadConfig(configPathF)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if path != "" {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	return artisum.ExportOPML(w, conf.Feeds, time.Now())
+}
+
+func listFeeds() error {
+	conf, err := artisum.LoadConfig(configPathF)
+	if err != nil {
+		return err
+	}
+	fileRepo := artisum.NewFileRepository(outputDirPath, conf.LLM.Summarize.Model, conf.Prompts.Language, time.Now())
+	states, err := fileRepo.GetFeedStates()
+	if err != nil {
+		return err
+	}
+
+	for _, f := range conf.Feeds {
+		line := f.URL
+		if f.Name != "" {
+			line = fmt.Sprintf("%s\t%s", line, f.Name)
+		}
+		if !f.IsEnabled() {
+			line += "\t(disabled)"
+		}
+		if state, ok := states[f.URL]; ok && state.ConsecutiveFailures > 0 {
+			line += fmt.Sprintf("\t(failed %d times in a row: %s)", state.ConsecutiveFailures, state.LastError)
+		}
+		fmt.Println(line)
+	}
+	return nil
+}
@@ -2,7 +2,9 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"log/slog"
 	"os"
 	"time"
@@ -12,18 +14,16 @@ import (
 
 var (
 	numOfSummaryF int
+	providerF     string
 	modelNameF    string
+	configPathF   string
+	offlineF      bool
 )
 
 const (
 	outputDirPath = ".artisum/result"
 )
 
-var (
-	notionToken      = os.Getenv("NOTION_TOKEN")
-	notionDatabaseID = os.Getenv("NOTION_DATABASE_ID")
-)
-
 func init() {
 	if _, err := os.Stat(outputDirPath); os.IsNotExist(err) {
 		if err := os.MkdirAll(outputDirPath, 0755); err != nil {
@@ -34,10 +34,13 @@ func init() {
 
 func main() {
 	flag.IntVar(&numOfSummaryF, "num", 3, "number of summary")
-	flag.StringVar(&modelNameF, "model", "gpt-4-turbo", "model name")
+	flag.StringVar(&providerF, "provider", "", "llm provider for selection and summarization (openai, anthropic, ollama, googleai)")
+	flag.StringVar(&modelNameF, "model", "", "model name for selection and summarization")
+	flag.StringVar(&configPathF, "config", ".artisum.json", "config file path")
+	flag.BoolVar(&offlineF, "offline", false, "read feeds from the local cache instead of fetching them")
 	flag.Parse()
 
-	slog.Info("start artisum", slog.String("model", modelNameF), slog.Int("num", numOfSummaryF))
+	slog.Info("start artisum", slog.String("provider", providerF), slog.String("model", modelNameF), slog.Int("num", numOfSummaryF))
 
 	if err := run(); err != nil {
 		panic(err)
@@ -47,16 +50,46 @@ func main() {
 }
 
 func run() error {
+	switch flag.Arg(0) {
+	case "feeds":
+		return runFeeds(flag.Args()[1:])
+	case "site":
+		return runSite(flag.Args()[1:])
+	case "", "resume":
+	default:
+		return fmt.Errorf("unknown command: %s", flag.Arg(0))
+	}
+	if flag.Arg(0) == "resume" && flag.NArg() != 2 {
+		return errors.New("usage: artisum resume <run-id>")
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
 	defer cancel()
 
+	conf, err := artisum.LoadConfig(configPathF)
+	if err != nil {
+		return err
+	}
+	conf.LLM.Override(providerF, modelNameF)
+	if offlineF {
+		conf.FeedFetch.Offline = true
+	}
+
 	now := time.Now()
-	notionRepo := artisum.NewNotionRepository(notionToken, notionDatabaseID)
-	fileRepo := artisum.NewFileRepository(outputDirPath, modelNameF, time.Now())
-	a, err := artisum.NewArtisum(numOfSummaryF, modelNameF, now, notionRepo, fileRepo)
+	fileRepo := artisum.NewFileRepository(outputDirPath, conf.LLM.Summarize.Model, conf.Prompts.Language, time.Now())
+	sinks, err := artisum.NewSinks(conf.Sinks, &artisum.SinkDeps{Summary: conf.Summary, FileRepo: fileRepo, Language: conf.Prompts.Language, Now: now})
+	if err != nil {
+		return err
+	}
+	a, err := artisum.NewArtisum(ctx, conf, numOfSummaryF, now, sinks, fileRepo)
 	if err != nil {
 		return err
 	}
 
-	return a.Summary(ctx)
+	if flag.Arg(0) == "resume" {
+		_, err = a.Resume(ctx, flag.Arg(1))
+		return err
+	}
+	_, err = a.Summary(ctx)
+	return err
 }
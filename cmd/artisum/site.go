@@ -0,0 +1,31 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/kazdevl/artisum"
+)
+
+const siteUsage = "usage: artisum site build [output dir]"
+
+func runSite(args []string) error {
+	if len(args) == 0 || args[0] != "build" || len(args) > 2 {
+		return errors.New(siteUsage)
+	}
+
+	conf, err := artisum.LoadConfig(configPathF)
+	if err != nil {
+		return err
+	}
+	if len(args) == 2 {
+		conf.Site.OutputDir = args[1]
+	}
+
+	fileRepo := artisum.NewFileRepository(outputDirPath, conf.LLM.Summarize.Model, conf.Prompts.Language, time.Now())
+	archives, err := fileRepo.GetSummaryArchives()
+	if err != nil {
+		return err
+	}
+	return artisum.BuildSite(conf.Site, conf.Prompts.Language, archives)
+}
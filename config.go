@@ -1,16 +1,242 @@
 package artisum
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
 )
 
 type Config struct {
-	Urls []string       `json:"urls"`
-	Tags []*InterestTag `json:"tags"`
+	Feeds     []*FeedConfig    `json:"urls"`
+	Tags      []*InterestTag   `json:"tags"`
+	LLM       *LLMsConfig      `json:"llm,omitempty"`
+	FeedFetch *FeedFetchConfig `json:"feedFetch,omitempty"`
+	Fetcher   *FetcherConfig   `json:"fetcher,omitempty"`
+	Prompts   *PromptsConfig   `json:"prompts,omitempty"`
+	Summary   *SummarySchema   `json:"summary,omitempty"`
+	Embedding *EmbeddingConfig `json:"embedding,omitempty"`
+	// Sinks receive every summary. Notion is the only sink when none is configured.
+	Sinks []*SinkConfig `json:"sinks,omitempty"`
+	Site  *SiteConfig   `json:"site,omitempty"`
+	// FailureThreshold is the ratio (0 to 1) of failed articles tolerated before a run is reported as failed.
+	FailureThreshold float64 `json:"failureThreshold,omitempty"`
 }
 
 func LoadConfig(path string) (*Config, error) {
+	c, err := readConfig(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.LLM == nil {
+		c.LLM = &LLMsConfig{}
+	}
+	c.LLM.setDefaults()
+	if c.FeedFetch == nil {
+		c.FeedFetch = &FeedFetchConfig{}
+	}
+	c.FeedFetch.setDefaults()
+	if c.Fetcher == nil {
+		c.Fetcher = &FetcherConfig{}
+	}
+	c.Fetcher.setDefaults()
+	if c.Prompts == nil {
+		c.Prompts = &PromptsConfig{}
+	}
+	c.Prompts.setDefaults()
+	if c.Summary == nil {
+		c.Summary = &SummarySchema{}
+	}
+	c.Summary.setDefaults(c.Prompts.Language)
+	if err := c.Summary.validate(); err != nil {
+		return nil, err
+	}
+	if len(c.Sinks) == 0 {
+		c.Sinks = defaultSinks()
+	}
+	for _, sink := range c.Sinks {
+		sink.setDefaults()
+	}
+	if c.Site == nil {
+		c.Site = &SiteConfig{}
+	}
+	c.Site.setDefaults()
+	return c, nil
+}
+
+// UpdateConfig rewrites the config file with the changes made by fn.
+// Defaults filled by LoadConfig are not written back. The top-level entries fn did not change are kept as they are,
+// with any keys Config does not know, and the file is replaced only when the new one is completely written.
+func UpdateConfig(path string, fn func(*Config) error) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	members, err := decodeConfigMembers(b)
+	if err != nil {
+		return err
+	}
+	var c *Config
+	if err := json.Unmarshal(b, &c); err != nil {
+		return err
+	}
+	before, err := encodeConfigMembers(c)
+	if err != nil {
+		return err
+	}
+	if err := fn(c); err != nil {
+		return err
+	}
+	after, err := encodeConfigMembers(c)
+	if err != nil {
+		return err
+	}
+
+	var merged []*configMember
+	for _, m := range members {
+		value, known := before[m.Key]
+		changed, ok := after[m.Key]
+		switch {
+		case !ok && known:
+			// fn removed the entry.
+			continue
+		case ok && !bytes.Equal(value, changed):
+			m = &configMember{Key: m.Key, Value: changed}
+		}
+		merged = append(merged, m)
+		delete(after, m.Key)
+	}
+	for _, key := range sortedKeys(after) {
+		merged = append(merged, &configMember{Key: key, Value: after[key]})
+	}
+
+	out, err := marshalConfigMembers(merged)
+	if err != nil {
+		return err
+	}
+	return replaceFile(path, out)
+}
+
+// configMember is a top-level entry of the config file.
+type configMember struct {
+	Key   string
+	Value json.RawMessage
+}
+
+// decodeConfigMembers returns the top-level entries of the config file in the order they are written.
+func decodeConfigMembers(b []byte) ([]*configMember, error) {
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	if t, err := decoder.Token(); err != nil {
+		return nil, err
+	} else if t != json.Delim('{') {
+		return nil, errors.New("the config is not a JSON object")
+	}
+	var members []*configMember
+	for decoder.More() {
+		t, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		m := &configMember{Key: t.(string)}
+		if err := decoder.Decode(&m.Value); err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+	return members, nil
+}
+
+func encodeConfigMembers(c *Config) (map[string]json.RawMessage, error) {
+	var b bytes.Buffer
+	encoder := json.NewEncoder(&b)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(c); err != nil {
+		return nil, err
+	}
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(b.Bytes(), &members); err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+func marshalConfigMembers(members []*configMember) ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteString("{")
+	for i, m := range members {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		key, err := json.Marshal(m.Key)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&b, "\n    %s: ", key)
+		if err := json.Indent(&b, m.Value, "    ", "    "); err != nil {
+			return nil, err
+		}
+	}
+	if len(members) > 0 {
+		b.WriteString("\n")
+	}
+	b.WriteString("}\n")
+	return b.Bytes(), nil
+}
+
+// replaceFile writes b to a temporary file next to path and renames it over path, keeping the mode of path.
+func replaceFile(path string, b []byte) (err error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(f.Name())
+		}
+	}()
+
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(info.Mode().Perm()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// AddFeeds adds the feeds whose URL is not registered yet and returns them.
+func (c *Config) AddFeeds(feeds ...*FeedConfig) []*FeedConfig {
+	registered := make(map[string]bool, len(c.Feeds))
+	for _, f := range c.Feeds {
+		registered[CanonicalizeURL(f.URL)] = true
+	}
+
+	var added []*FeedConfig
+	for _, f := range feeds {
+		canonical := CanonicalizeURL(f.URL)
+		if registered[canonical] {
+			continue
+		}
+		registered[canonical] = true
+		c.Feeds = append(c.Feeds, f)
+		added = append(added, f)
+	}
+	return added
+}
+
+func readConfig(path string) (*Config, error) {
 	f, err := os.Open(path)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,98 @@
+package artisum
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testConfig = `{
+    "comment": "kept as it is",
+    "urls": [
+        "https://a.example.com/feed?format=rss&lang=ja"
+    ],
+    "tags": [
+        {"name": "golang", "level": 3}
+    ],
+    "llm": {
+        "select": {"provider": "openai", "model": "gpt-4o"},
+        "experimental": true
+    }
+}
+`
+
+func TestUpdateConfigAddFeeds(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".artisum.json")
+	if err := os.WriteFile(path, []byte(testConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	err := UpdateConfig(path, func(c *Config) error {
+		added := c.AddFeeds(
+			&FeedConfig{URL: "https://a.example.com/feed?format=rss&lang=ja"},
+			&FeedConfig{URL: "https://b.example.com/feed?a=1&b=2", Name: "B"},
+		)
+		if len(added) != 1 {
+			t.Errorf("added %d feeds, want 1", len(added))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conf.Feeds) != 2 || conf.Feeds[1].URL != "https://b.example.com/feed?a=1&b=2" || conf.Feeds[1].Name != "B" {
+		t.Errorf("feeds = %+v", conf.Feeds)
+	}
+	if len(conf.Tags) != 1 || conf.LLM.Select.Model != "gpt-4o" {
+		t.Errorf("config = %+v", conf)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	written := string(b)
+	for _, want := range []string{`"comment": "kept as it is"`, `"experimental": true`, `"https://b.example.com/feed?a=1&b=2"`} {
+		if !strings.Contains(written, want) {
+			t.Errorf("%s is not written:\n%s", want, written)
+		}
+	}
+	if strings.Contains(written, `\u0026`) {
+		t.Errorf("& is escaped:\n%s", written)
+	}
+	if strings.Index(written, `"comment"`) > strings.Index(written, `"urls"`) {
+		t.Errorf("the order of the entries is changed:\n%s", written)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("mode = %v, %v", info.Mode(), err)
+	}
+	if entries, err := os.ReadDir(filepath.Dir(path)); err != nil || len(entries) != 1 {
+		t.Errorf("temporary files are left behind: %v, %v", entries, err)
+	}
+}
+
+func TestUpdateConfigFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".artisum.json")
+	if err := os.WriteFile(path, []byte(testConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	wantErr := errors.New("failed")
+	err := UpdateConfig(path, func(c *Config) error {
+		c.Feeds = nil
+		return wantErr
+	})
+	if !errors.Is(err, wantErr) {
+		t.Fatalf("err = %v, want %v", err, wantErr)
+	}
+	if b, err := os.ReadFile(path); err != nil || string(b) != testConfig {
+		t.Errorf("the config is changed: %s, %v", b, err)
+	}
+}
@@ -0,0 +1,104 @@
+package artisum
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"net/url"
+	"strings"
+
+	"github.com/mmcdole/gofeed"
+	"golang.org/x/net/html"
+)
+
+var feedMediaTypes = map[string]bool{
+	"application/rss+xml":   true,
+	"application/atom+xml":  true,
+	"application/feed+json": true,
+	"application/json":      true,
+	"application/xml":       true,
+	"text/xml":              true,
+}
+
+// DiscoverFeeds returns the feed URLs for siteURL. When siteURL is already a feed it is returned as is,
+// otherwise the feeds advertised by <link rel="alternate"> in the page are returned.
+func DiscoverFeeds(ctx context.Context, fetcher *Fetcher, siteURL string) ([]string, error) {
+	resp, err := fetcher.Fetch(ctx, siteURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.MediaType != "text/html" {
+		if _, err := gofeed.NewParser().Parse(bytes.NewReader(resp.Body)); err == nil {
+			return []string{resp.URL.String()}, nil
+		}
+	}
+
+	body, err := decodeCharset(resp.Body, resp.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, err
+	}
+	doc, err := html.Parse(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	feeds := findFeedLinks(doc, resp.URL)
+	if len(feeds) == 0 {
+		return nil, fmt.Errorf("no feed is found in %s", siteURL)
+	}
+	return feeds, nil
+}
+
+func findFeedLinks(doc *html.Node, base *url.URL) []string {
+	var (
+		feeds []string
+		seen  = make(map[string]bool)
+	)
+
+	var f func(*html.Node)
+	f = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "base" {
+			if href := attr(n, "href"); href != "" {
+				if u, err := base.Parse(href); err == nil {
+					base = u
+				}
+			}
+		}
+		if n.Type == html.ElementNode && n.Data == "link" && isFeedLink(n) {
+			if u, err := base.Parse(attr(n, "href")); err == nil && !seen[u.String()] {
+				seen[u.String()] = true
+				feeds = append(feeds, u.String())
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			f(c)
+		}
+	}
+	f(doc)
+
+	return feeds
+}
+
+func isFeedLink(n *html.Node) bool {
+	if attr(n, "href") == "" {
+		return false
+	}
+	isAlternate := false
+	for _, rel := range strings.Fields(strings.ToLower(attr(n, "rel"))) {
+		if rel == "alternate" {
+			isAlternate = true
+		}
+	}
+	mediaType, _, _ := mime.ParseMediaType(attr(n, "type"))
+	return isAlternate && feedMediaTypes[mediaType]
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if strings.EqualFold(a.Key, key) {
+			return a.Val
+		}
+	}
+	return ""
+}
@@ -0,0 +1,57 @@
+package artisum
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestDiscoverFeeds(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/blog/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = io.WriteString(w, `<html><head>
+<link rel="alternate" type="application/rss+xml" href="feed.xml">
+<link rel="alternate" type="application/atom+xml; charset=utf-8" href="/atom.xml">
+<link rel="alternate" type="application/rss+xml" href="/blog/feed.xml">
+<link rel="alternate" hreflang="en" href="/en/">
+<link rel="stylesheet" type="text/css" href="/style.css">
+</head><body></body></html>`)
+	})
+	mux.HandleFunc("/feed.xml", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/rss+xml")
+		_, _ = io.WriteString(w, testRSS)
+	})
+	mux.HandleFunc("/empty/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = io.WriteString(w, `<html><head><title>no feed</title></head></html>`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	fetcher := newTestFetcher()
+	ctx := context.Background()
+
+	feeds, err := DiscoverFeeds(ctx, fetcher, server.URL+"/blog/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{server.URL + "/blog/feed.xml", server.URL + "/atom.xml"}; !reflect.DeepEqual(feeds, want) {
+		t.Errorf("got %q, want %q", feeds, want)
+	}
+
+	feeds, err = DiscoverFeeds(ctx, fetcher, server.URL+"/feed.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{server.URL + "/feed.xml"}; !reflect.DeepEqual(feeds, want) {
+		t.Errorf("got %q for a feed url, want %q", feeds, want)
+	}
+
+	if _, err := DiscoverFeeds(ctx, fetcher, server.URL+"/empty/"); err == nil {
+		t.Error("no error for a page without feeds")
+	}
+}
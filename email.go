@@ -0,0 +1,345 @@
+package artisum
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+const emailTimeout = 30 * time.Second
+
+//go:embed templates/email
+var defaultEmailTemplateFS embed.FS
+
+var emailTemplateFuncs = map[string]any{
+	"hasPrefix": strings.HasPrefix,
+}
+
+type EmailSinkConfig struct {
+	Host string `json:"host"`
+	// Port defaults to 587. Port 465 is connected with TLS, the others upgrade with STARTTLS when the server supports it.
+	Port        int    `json:"port,omitempty"`
+	Username    string `json:"username,omitempty"`
+	PasswordEnv string `json:"passwordEnv,omitempty"`
+	From        string `json:"from"`
+	// To receive every article, and TagRecipients receive the articles of the tag.
+	To            []string            `json:"to,omitempty"`
+	TagRecipients map[string][]string `json:"tagRecipients,omitempty"`
+	// Subject is formatted with the date. It defaults to the digest title in the prompt language.
+	Subject string `json:"subject,omitempty"`
+	// HTMLTemplate and TextTemplate are template files replacing the built-in ones.
+	HTMLTemplate string `json:"htmlTemplate,omitempty"`
+	TextTemplate string `json:"textTemplate,omitempty"`
+}
+
+// EmailSink sends a digest of the summaries of a run over SMTP, grouped by tag, as HTML with a plain text alternative.
+type EmailSink struct {
+	conf         *EmailSinkConfig
+	password     string
+	date         string
+	labels       *summaryLabels
+	htmlTemplate *htmltemplate.Template
+	textTemplate *texttemplate.Template
+}
+
+type emailDigest struct {
+	Subject  string
+	Date     string
+	Labels   *summaryLabels
+	MaxScore int
+	Groups   []*emailTagGroup
+}
+
+type emailTagGroup struct {
+	Tag      string
+	Articles []*SummaryArticle
+}
+
+func newEmailSink(conf *SinkConfig, deps *SinkDeps) (SummaryStore, error) {
+	options := &EmailSinkConfig{
+		Port:        587,
+		PasswordEnv: "SMTP_PASSWORD",
+	}
+	if err := conf.decodeOptions(options); err != nil {
+		return nil, err
+	}
+	return NewEmailSink(options, deps.Language, deps.Now)
+}
+
+func NewEmailSink(conf *EmailSinkConfig, language string, now time.Time) (*EmailSink, error) {
+	if conf.Host == "" || conf.From == "" {
+		return nil, errors.New("host and from have to be set")
+	}
+	if len(conf.To) == 0 && len(conf.TagRecipients) == 0 {
+		return nil, errors.New("no recipients")
+	}
+
+	htmlSource, err := readEmailTemplate(conf.HTMLTemplate, "digest.html.tmpl")
+	if err != nil {
+		return nil, err
+	}
+	htmlTemplate, err := htmltemplate.New("html").Funcs(emailTemplateFuncs).Parse(htmlSource)
+	if err != nil {
+		return nil, fmt.Errorf("invalid html template: %w", err)
+	}
+	textSource, err := readEmailTemplate(conf.TextTemplate, "digest.txt.tmpl")
+	if err != nil {
+		return nil, err
+	}
+	textTemplate, err := texttemplate.New("text").Funcs(emailTemplateFuncs).Parse(textSource)
+	if err != nil {
+		return nil, fmt.Errorf("invalid text template: %w", err)
+	}
+
+	return &EmailSink{
+		conf:         conf,
+		password:     os.Getenv(conf.PasswordEnv),
+		date:         now.Format(time.DateOnly),
+		labels:       labelsFor(language),
+		htmlTemplate: htmlTemplate,
+		textTemplate: textTemplate,
+	}, nil
+}
+
+func readEmailTemplate(file, name string) (string, error) {
+	if file != "" {
+		b, err := os.ReadFile(file)
+		return string(b), err
+	}
+	b, err := defaultEmailTemplateFS.ReadFile("templates/email/" + name)
+	return string(b), err
+}
+
+// SaveSummaryResult does nothing, as the articles are sent together by Flush.
+func (s *EmailSink) SaveSummaryResult(context.Context, *SummaryArticle) (string, error) {
+	return "", nil
+}
+
+// Flush sends each recipient one email with the articles it receives and has not received yet.
+// The progress is recorded per address, so that a resumed flush only sends to the addresses which failed.
+func (s *EmailSink) Flush(ctx context.Context, articles []*SummaryArticle, progress FlushProgress) error {
+	recipients := s.recipients(articles)
+	addresses := make([]string, 0, len(recipients))
+	for address := range recipients {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+
+	var errs []error
+	for _, address := range addresses {
+		pending := undelivered(recipients[address], progress, address)
+		if len(pending) == 0 {
+			continue
+		}
+		message, err := s.message(address, pending)
+		if err != nil {
+			return err
+		}
+		if err := s.send(ctx, address, message); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", address, err))
+			continue
+		}
+		slog.Info("sent email digest", slog.String("to", address), slog.Int("articles", len(pending)))
+		if err := progress.MarkDelivered(address, pending); err != nil {
+			return err
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// recipients returns the articles for each address, keeping the order of the run.
+func (s *EmailSink) recipients(articles []*SummaryArticle) map[string][]*SummaryArticle {
+	recipients := make(map[string][]*SummaryArticle)
+	for _, article := range articles {
+		addresses := append([]string(nil), s.conf.To...)
+		for tag, tagAddresses := range s.conf.TagRecipients {
+			if strings.EqualFold(tag, article.Origin.Tag) {
+				addresses = append(addresses, tagAddresses...)
+			}
+		}
+		added := make(map[string]bool, len(addresses))
+		for _, address := range addresses {
+			if !added[address] {
+				added[address] = true
+				recipients[address] = append(recipients[address], article)
+			}
+		}
+	}
+	return recipients
+}
+
+func (s *EmailSink) message(to string, articles []*SummaryArticle) ([]byte, error) {
+	subject := s.labels.DigestTitle(s.date)
+	if s.conf.Subject != "" {
+		subject = fmt.Sprintf(s.conf.Subject, s.date)
+	}
+	digest := &emailDigest{
+		Subject:  subject,
+		Date:     s.date,
+		Labels:   s.labels,
+		MaxScore: maxSelectionScore,
+		Groups:   groupByTag(articles),
+	}
+	var html, text bytes.Buffer
+	if err := s.htmlTemplate.Execute(&html, digest); err != nil {
+		return nil, err
+	}
+	if err := s.textTemplate.Execute(&text, digest); err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	writer := multipart.NewWriter(&b)
+	for _, h := range [][2]string{
+		{"From", s.conf.From},
+		{"To", to},
+		{"Subject", mime.QEncoding.Encode("utf-8", digest.Subject)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"Message-ID", s.messageID()},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "multipart/alternative; boundary=" + writer.Boundary()},
+	} {
+		fmt.Fprintf(&b, "%s: %s\r\n", h[0], h[1])
+	}
+	b.WriteString("\r\n")
+
+	// The plain text comes first, as the last alternative is the preferred one.
+	for _, part := range []struct {
+		contentType string
+		body        []byte
+	}{
+		{"text/plain; charset=utf-8", text.Bytes()},
+		{"text/html; charset=utf-8", html.Bytes()},
+	} {
+		w, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		if _, err := qp.Write(part.body); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func (s *EmailSink) messageID() string {
+	b := make([]byte, 12)
+	_, _ = rand.Read(b)
+	domain := "artisum"
+	if _, d, ok := strings.Cut(s.conf.From, "@"); ok {
+		domain = strings.Trim(d, "> ")
+	}
+	return fmt.Sprintf("<%s.%s@%s>", s.date, hex.EncodeToString(b), domain)
+}
+
+func (s *EmailSink) send(ctx context.Context, to string, message []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, emailTimeout)
+	defer cancel()
+
+	addr := net.JoinHostPort(s.conf.Host, strconv.Itoa(s.conf.Port))
+	tlsConfig := &tls.Config{ServerName: s.conf.Host}
+	var (
+		conn net.Conn
+		err  error
+	)
+	if s.conf.Port == 465 {
+		conn, err = (&tls.Dialer{Config: tlsConfig}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, s.conf.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok && s.conf.Port != 465 {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if s.conf.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", s.conf.Username, s.password, s.conf.Host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(envelopeAddress(s.conf.From)); err != nil {
+		return err
+	}
+	if err := client.Rcpt(envelopeAddress(to)); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, bytes.NewReader(message)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// envelopeAddress returns the bare address of "Name <address>".
+func envelopeAddress(s string) string {
+	if start, end := strings.LastIndex(s, "<"), strings.LastIndex(s, ">"); start >= 0 && end > start {
+		return s[start+1 : end]
+	}
+	return strings.TrimSpace(s)
+}
+
+// groupByTag groups the articles by tag in the order the tags first appear.
+func groupByTag(articles []*SummaryArticle) []*emailTagGroup {
+	var groups []*emailTagGroup
+	index := make(map[string]*emailTagGroup)
+	for _, article := range articles {
+		group, ok := index[article.Origin.Tag]
+		if !ok {
+			group = &emailTagGroup{Tag: article.Origin.Tag}
+			index[article.Origin.Tag] = group
+			groups = append(groups, group)
+		}
+		group.Articles = append(group.Articles, article)
+	}
+	return groups
+}
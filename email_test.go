@@ -0,0 +1,245 @@
+package artisum
+
+import (
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type smtpDelivery struct {
+	from, to string
+	data     string
+}
+
+// smtpStub is an SMTP server which accepts every message, except for the recipients in reject.
+type smtpStub struct {
+	listener   net.Listener
+	mu         sync.Mutex
+	deliveries []*smtpDelivery
+	reject     map[string]bool
+}
+
+func newSMTPStub(t *testing.T) *smtpStub {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpStub{listener: listener, reject: make(map[string]bool)}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *smtpStub) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *smtpStub) serve(conn net.Conn) {
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+	reply := func(line string) { _ = text.PrintfLine("%s", line) }
+
+	reply("220 localhost ESMTP")
+	delivery := &smtpDelivery{}
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(strings.Fields(line + " ")[0])
+		switch command {
+		case "EHLO", "HELO":
+			reply("250 localhost")
+		case "MAIL":
+			delivery.from = strings.Trim(strings.TrimPrefix(line, "MAIL FROM:"), "<>")
+			reply("250 OK")
+		case "RCPT":
+			delivery.to = strings.Trim(strings.TrimPrefix(line, "RCPT TO:"), "<>")
+			s.mu.Lock()
+			rejected := s.reject[delivery.to]
+			s.mu.Unlock()
+			if rejected {
+				reply("550 mailbox unavailable")
+				continue
+			}
+			reply("250 OK")
+		case "DATA":
+			reply("354 go ahead")
+			data, err := io.ReadAll(text.DotReader())
+			if err != nil {
+				return
+			}
+			delivery.data = string(data)
+			s.mu.Lock()
+			s.deliveries = append(s.deliveries, delivery)
+			s.mu.Unlock()
+			delivery = &smtpDelivery{}
+			reply("250 OK")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+func (s *smtpStub) received() []*smtpDelivery {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*smtpDelivery(nil), s.deliveries...)
+}
+
+// parseDigest returns the subject and the plain text and HTML parts of the message.
+func parseDigest(t *testing.T, data string) (string, map[string]string) {
+	t.Helper()
+	msg, err := mail.ReadMessage(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("content type = %q, %v", mediaType, err)
+	}
+	parts := make(map[string]string)
+	var order []string
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The reader decodes quoted-printable parts.
+		body, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		parts[partType] = string(body)
+		order = append(order, partType)
+	}
+	if strings.Join(order, ",") != "text/plain,text/html" {
+		t.Errorf("parts = %q", order)
+	}
+	return subject, parts
+}
+
+func TestEmailSinkFlush(t *testing.T) {
+	stub := newSMTPStub(t)
+	stub.reject["rust@example.com"] = true
+
+	conf := &EmailSinkConfig{
+		Host:          "127.0.0.1",
+		Port:          stub.port(),
+		From:          "artisum <artisum@example.com>",
+		To:            []string{"all@example.com"},
+		TagRecipients: map[string][]string{"Rust": {"rust@example.com", "all@example.com"}},
+	}
+	sink, err := NewEmailSink(conf, "en", time.Date(2024, 8, 13, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	articles := testSummaryArticles(2)
+	articles[1].Origin.Tag = "rust"
+	articles[1].Origin.Reason = "async runtime"
+	articles[1].Origin.Score = 7
+	progress := &memoryFlushProgress{}
+
+	err = sink.Flush(context.Background(), articles, progress)
+	if err == nil || !strings.Contains(err.Error(), "rust@example.com") {
+		t.Fatalf("err = %v", err)
+	}
+	deliveries := stub.received()
+	if len(deliveries) != 1 || deliveries[0].to != "all@example.com" || deliveries[0].from != "artisum@example.com" {
+		t.Fatalf("deliveries = %+v", deliveries)
+	}
+
+	subject, parts := parseDigest(t, deliveries[0].data)
+	if subject != "Article summaries for 2024-08-13" {
+		t.Errorf("subject = %q", subject)
+	}
+	text := parts["text/plain"]
+	for _, want := range []string{"==== golang ====", "==== rust ====", "■ Article 1", "Why it was selected: 7/10 async runtime", "[Keywords]\n- go\n- generics", "notion: https://notion.so/0"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("text does not contain %q:\n%s", want, text)
+		}
+	}
+	html := parts["text/html"]
+	for _, want := range []string{`<html lang="en">`, `<a href="https://example.com/0">Article 0</a>`, "About &lt;generics&gt; &amp; more.", `<a href="https://notion.so/1">Read on notion</a>`} {
+		if !strings.Contains(html, want) {
+			t.Errorf("html does not contain %q:\n%s", want, html)
+		}
+	}
+
+	// The resumed flush sends only to the address which failed, with the articles of its tag.
+	stub.mu.Lock()
+	stub.reject = nil
+	stub.mu.Unlock()
+	if err := sink.Flush(context.Background(), articles, progress); err != nil {
+		t.Fatal(err)
+	}
+	deliveries = stub.received()
+	if len(deliveries) != 2 || deliveries[1].to != "rust@example.com" {
+		t.Fatalf("deliveries = %+v", deliveries)
+	}
+	_, parts = parseDigest(t, deliveries[1].data)
+	if text := parts["text/plain"]; strings.Contains(text, "golang") || !strings.Contains(text, "■ Article 1") {
+		t.Errorf("text = %s", text)
+	}
+	if got := progress.delivered["all@example.com"]; len(got) != 2 {
+		t.Errorf("delivered to all@example.com: %q", got)
+	}
+	if got := progress.delivered["rust@example.com"]; len(got) != 1 || got[0] != "https://example.com/1" {
+		t.Errorf("delivered to rust@example.com: %q", got)
+	}
+
+	if err := sink.Flush(context.Background(), articles, progress); err != nil || len(stub.received()) != 2 {
+		t.Errorf("flushed again: %d deliveries, %v", len(stub.received()), err)
+	}
+}
+
+func TestEmailSinkSubject(t *testing.T) {
+	conf := &EmailSinkConfig{Host: "localhost", From: "artisum@example.com", To: []string{"a@example.com"}, Subject: "[digest] %s"}
+	sink, err := NewEmailSink(conf, "ja", time.Date(2024, 8, 13, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	message, err := sink.message("a@example.com", testSummaryArticles(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, parts := parseDigest(t, string(message))
+	if subject != "[digest] 2024-08-13" || !strings.HasPrefix(parts["text/plain"], "2024-08-13 の記事要約") {
+		t.Errorf("subject = %q, text = %q", subject, parts["text/plain"])
+	}
+	if _, err := NewEmailSink(&EmailSinkConfig{Host: "localhost", From: "a@example.com"}, "ja", time.Now()); err == nil {
+		t.Error("a sink without recipients is created")
+	}
+	if got := envelopeAddress("artisum <artisum@example.com>"); got != "artisum@example.com" {
+		t.Errorf("envelope address = %q", got)
+	}
+}
@@ -4,14 +4,17 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/url"
+	"strings"
 
 	"github.com/samber/lo"
 	"github.com/tmc/langchaingo/chains"
 	"github.com/tmc/langchaingo/documentloaders"
 	"github.com/tmc/langchaingo/llms"
-	"github.com/tmc/langchaingo/llms/openai"
 	"github.com/tmc/langchaingo/prompts"
+	"github.com/tmc/langchaingo/schema"
 	"github.com/tmc/langchaingo/textsplitter"
 )
 
@@ -19,168 +22,175 @@ type InterestArticle struct {
 	Title string
 	Tag   string
 	URL   string
+	// Score is the relevance to the tag judged by the LLM, from 1 to maxSelectionScore, and Reason explains it.
+	Score  int    `json:",omitempty"`
+	Reason string `json:",omitempty"`
+	// EmbeddingScore is the relevance given by the Ranker. It is 0 when ranking is disabled.
+	EmbeddingScore float64 `json:",omitempty"`
 }
 
 type InterestTag struct {
 	Name  string `json:"name"`
 	Level int    `json:"level"`
+	// Description and Examples (titles or excerpts of typical articles) make up the profile the Ranker compares articles with.
+	Description string   `json:"description,omitempty"`
+	Examples    []string `json:"examples,omitempty"`
+	// Filter drops articles before the LLM selects them. A tag without a filter considers every article.
+	Filter *ArticleFilter `json:"filter,omitempty"`
 }
 
 type PromptArticle struct {
-	FeedURL string
-	URL     string
-	Title   string
-	Content string
+	FeedURL  string
+	FeedName string   `json:",omitempty"`
+	FeedTags []string `json:",omitempty"`
+	Weight   float64
+	Language string `json:",omitempty"`
+	// AllowedTags are the only tags the article can be selected for. Any tag is allowed when it is empty.
+	AllowedTags []string `json:",omitempty"`
+	URL         string
+	Title       string
+	Content     string
+}
+
+// RejectedArticle is a candidate the LLM did not select, with the reason.
+type RejectedArticle struct {
+	Title  string
+	URL    string
+	Reason string
 }
 
 type PromptResult struct {
 	ExtractedArticles []*InterestArticle `json:"ExtractedArticles"`
+	RejectedArticles  []*RejectedArticle `json:"RejectedArticles,omitempty"`
 }
 
-type Extracter struct {
-	modelName              string
-	gpt35Turbo             *openai.LLM
-	tags                   []*InterestTag
-	mapReduceDocumentChain chains.MapReduceDocuments
-	toJsonPromptTemplate   prompts.PromptTemplate
+const maxSelectionScore = 10
+
+var promptResultSchema = &OutputSchema{
+	Name:        "extracted_articles",
+	Description: "Report the articles extracted from the technical articles.",
+	Parameters: map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"ExtractedArticles": map[string]any{
+				"type": "array",
+				"items": map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"Title":  map[string]any{"type": "string"},
+						"Tag":    map[string]any{"type": "string"},
+						"URL":    map[string]any{"type": "string"},
+						"Score":  map[string]any{"type": "integer", "minimum": 1, "maximum": maxSelectionScore},
+						"Reason": map[string]any{"type": "string"},
+					},
+					"required":             []string{"Title", "Tag", "URL", "Score", "Reason"},
+					"additionalProperties": false,
+				},
+			},
+			"RejectedArticles": map[string]any{
+				"type": "array",
+				"items": map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"Title":  map[string]any{"type": "string"},
+						"URL":    map[string]any{"type": "string"},
+						"Reason": map[string]any{"type": "string"},
+					},
+					"required":             []string{"Title", "URL", "Reason"},
+					"additionalProperties": false,
+				},
+			},
+		},
+		"required":             []string{"ExtractedArticles"},
+		"additionalProperties": false,
+	},
 }
 
-func NewExtracter(modelName string, numOfSummary int, tags []*InterestTag) (*Extracter, error) {
-	llm, err := openai.New(
-		openai.WithModel(modelName),
-		openai.WithCallback(NewArtisumLogHandler("興味対象から記事抽出")))
-	if err != nil {
-		return nil, err
-	}
-
-	gpt35Turbo, err := openai.New(openai.WithModel("gpt-3.5-turbo"))
-	if err != nil {
-		return nil, err
+func (r *PromptResult) Validate() error {
+	if r.ExtractedArticles == nil {
+		return errors.New(`"ExtractedArticles" is missing`)
 	}
-
-	tasgByte, err := json.Marshal(tags)
-	if err != nil {
-		return nil, err
+	var errs []error
+	for i, a := range r.ExtractedArticles {
+		if a == nil {
+			errs = append(errs, fmt.Errorf("ExtractedArticles[%d] is null", i))
+			continue
+		}
+		if strings.TrimSpace(a.Title) == "" {
+			errs = append(errs, fmt.Errorf("ExtractedArticles[%d].Title is empty", i))
+		}
+		if strings.TrimSpace(a.Tag) == "" {
+			errs = append(errs, fmt.Errorf("ExtractedArticles[%d].Tag is empty", i))
+		}
+		if u, err := url.Parse(a.URL); err != nil || u.Host == "" {
+			errs = append(errs, fmt.Errorf("ExtractedArticles[%d].URL %q is not an absolute URL", i, a.URL))
+		}
+		if a.Score < 1 || a.Score > maxSelectionScore {
+			errs = append(errs, fmt.Errorf("ExtractedArticles[%d].Score %d is not from 1 to %d", i, a.Score, maxSelectionScore))
+		}
+		if strings.TrimSpace(a.Reason) == "" {
+			errs = append(errs, fmt.Errorf("ExtractedArticles[%d].Reason is empty", i))
+		}
 	}
-
-	llmPromptTemplate := prompts.NewPromptTemplate(fmt.Sprintf(`## Introduction
-		You are an excellent engineer with extensive experience in web application development.
-
-		Below, there are sections for "Areas of Technical Interest" and "Technical Articles".
-		In "Areas of Technical Interest", there is a JSON array of objects, each containing fields for Name and Level.
-		----json
-		[
-			{
-				"Name": "Sample",
-				"Level": 3
-			}
-		]
-		----
-		Name indicates the name of the interest, and Level indicates the degree of interest. The interest level is defined by numbers from 1 to 3, with higher numbers indicating greater interest.
-
-		In "Technical Articles", there is a JSON array of objects, each containing fields for FeedURL, URL, Title, and Content.
-		----json
-		[
-			{
-				"FeedURL": "http://sample/feed/content.com",
-				"URL": "https://sample.com",
-				"Title": "sample",
-				"Content": "samples content"
-				"Description": "sample description",
-			}
-		]
-		----
-
-		You are to extract articles of high interest from the "Technical Articles" data based on the data from "Areas of Technical Interest".
-		### Requirements for extraction:
-		- The number of articles to extract should be under 2.
-		- The number of articles to extract should be at least 1.
-		- The articles selected must be highly relevant to the interests.
-		- Choose articles that are important, versatile, and useful for web application product development.
-		- The output format of the extracted data should be in the following JSON format:
-		----json
-		{
-			"Title": "Sample",
-			"Tag": "Sample",
-			"URL": "https://sample.com",
+	for i, a := range r.RejectedArticles {
+		if a == nil {
+			errs = append(errs, fmt.Errorf("RejectedArticles[%d] is null", i))
 		}
-		----
-		"Tag" should use the "Name" from "Areas of Technical Interest" directly.
-		You have to get "Title", "ImageURL" value from "Technical Articles" directly.
-
-		When outputting the response results, please use only the Jsonized data of the extracted articles as the output content.
-
-		## "Technical Articles"
-		{{.context}}
-
-		## "Areas of Technical Interest"
-		%s
-		---
-	`, tasgByte), []string{"context"})
-
-	reducePromptTemplate := prompts.NewPromptTemplate(fmt.Sprintf(`
-	You are to extract articles from "## Articles"
-	### Requirements for extraction:
-	- The number of articles to extract should be %d.
-	- If there are %d or fewer articles, select all of them.
-	- If there are more than %d, choose articles that are important, versatile, and useful for web application product development.
-	- The output format of the extracted data should be in the following JSON format:
-	{
-		"ExtractedArticles": [
-			{
-				"Title": "Sample",
-				"Tag": "Sample",
-				"URL": "https://sample.com",
-			}
-			{
-				"Title": "Sample2",
-				"Tag": "Sample2",
-				"URL": "https://sample2.com",
-			}
-		]
 	}
+	return errors.Join(errs...)
+}
 
-	Ensure that "ExtractedArticles" value is always in array format, even if there is only one result.
-
-	## Articles
-	{{.context}}
-	`, numOfSummary, numOfSummary, numOfSummary), []string{"context"})
+const extractConcurrency = 4
 
-	toJsonPromptTemplate := prompts.NewPromptTemplate(`
-	Please convert the value of "##Json Data" into JSON format.
-	Ensure that the JSON format is correct and not missing any data.
-	## Json Data
-	{{.context}}
-	`, []string{"context"})
+type Extracter struct {
+	modelName            string
+	structured           *StructuredOutput
+	tags                 []*InterestTag
+	count                int
+	feeds                map[string]*FeedConfig
+	mapChain             chains.Chain
+	reducePromptTemplate prompts.PromptTemplate
+}
 
-	llmChain := chains.NewLLMChain(llm, llmPromptTemplate, chains.WithCallback(NewArtisumLogHandler("独立して興味対象の記事抽出")))
-	reduceChain := chains.NewLLMChain(llm, reducePromptTemplate, chains.WithCallback(NewArtisumLogHandler("記事抽出の最終まとめ")))
-	mapReduceDocumentChain := chains.NewMapReduceDocuments(llmChain, reduceChain)
+func NewExtracter(llm llms.Model, conf *LLMConfig, repairer *JSONRepairer, promptSet *PromptSet, count int, tags []*InterestTag, feeds []*FeedConfig) (*Extracter, error) {
+	mapChain := chains.NewLLMChain(llm, promptSet.Template(PromptSelect), chains.WithCallback(NewArtisumLogHandler("独立して興味対象の記事抽出")))
 
 	return &Extracter{
-		modelName:              modelName,
-		gpt35Turbo:             gpt35Turbo,
-		tags:                   tags,
-		mapReduceDocumentChain: mapReduceDocumentChain,
-		toJsonPromptTemplate:   toJsonPromptTemplate,
+		modelName:            conf.Model,
+		structured:           NewStructuredOutput(llm, conf, repairer),
+		tags:                 tags,
+		count:                count,
+		feeds:                lo.KeyBy(feeds, func(f *FeedConfig) string { return f.URL }),
+		mapChain:             mapChain,
+		reducePromptTemplate: promptSet.Template(PromptReduce),
 	}, nil
 }
 
-func (e *Extracter) Extract(ctx context.Context, articlesMap map[string][]*Article) ([]*InterestArticle, error) {
+// Extract returns the selected articles, checked against articlesMap, and the candidates the LLM rejected at the end.
+func (e *Extracter) Extract(ctx context.Context, articlesMap map[string][]*Article) ([]*InterestArticle, []*RejectedArticle, error) {
 	var articles []*PromptArticle
 	for feedURL, feedArticles := range articlesMap {
+		feed, ok := e.feeds[feedURL]
+		if !ok {
+			feed = &FeedConfig{URL: feedURL}
+		}
 		articles = append(articles, lo.Map(feedArticles, func(a *Article, _ int) *PromptArticle {
 			return &PromptArticle{
-				FeedURL: feedURL,
-				URL:     a.Url,
-				Title:   a.Title,
-				Content: a.Content,
+				FeedURL:     feedURL,
+				FeedName:    feed.Name,
+				FeedTags:    feed.Tags,
+				Weight:      feed.weight(),
+				Language:    feed.Language,
+				AllowedTags: a.AllowedTags,
+				URL:         a.Url,
+				Title:       a.Title,
+				Content:     a.Content,
 			}
 		})...)
 	}
 	promptArticles, err := json.Marshal(articles)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	reader := bytes.NewReader(promptArticles)
 	docs, err := documentloaders.NewText(reader).LoadAndSplit(ctx,
@@ -192,27 +202,35 @@ func (e *Extracter) Extract(ctx context.Context, articlesMap map[string][]*Artic
 		),
 	)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	result, err := e.mapReduceDocumentChain.Call(ctx, map[string]any{"input_documents": docs})
+	// Each chunk is narrowed down independently, then the candidates are narrowed down into the final result.
+	inputs := lo.Map(docs, func(doc schema.Document, _ int) map[string]any {
+		return map[string]any{"context": doc.PageContent}
+	})
+	results, err := chains.Apply(ctx, e.mapChain, inputs, extractConcurrency)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
+	candidates := lo.Map(results, func(result map[string]any, _ int) string {
+		text, _ := result["text"].(string)
+		return text
+	})
 
-	resultPrompt, err := e.toJsonPromptTemplate.Format(map[string]any{"context": result["text"]})
+	reducePrompt, err := e.reducePromptTemplate.Format(map[string]any{"context": strings.Join(candidates, "\n\n")})
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	jsonResult, err := e.gpt35Turbo.Call(ctx, resultPrompt, llms.WithJSONMode())
-	if err != nil {
-		return nil, err
-	}
-	var promptResult *PromptResult
-	err = json.Unmarshal([]byte(jsonResult), &promptResult)
-	if err != nil {
-		return nil, err
+	var promptResult PromptResult
+	if err := e.structured.Generate(ctx, reducePrompt, promptResultSchema, &promptResult); err != nil {
+		return nil, nil, err
 	}
 
-	return promptResult.ExtractedArticles, nil
+	validator := newSelectionValidator(articles, e.tags)
+	selected := validator.validate(promptResult.ExtractedArticles, parseCandidateArticles(candidates), e.count)
+	// An article topped up from the candidates is no longer rejected.
+	selectedURLs := lo.SliceToMap(selected, func(a *InterestArticle) (string, bool) { return CanonicalizeURL(a.URL), true })
+	rejected := lo.Filter(promptResult.RejectedArticles, func(a *RejectedArticle, _ int) bool { return a != nil && !selectedURLs[CanonicalizeURL(a.URL)] })
+	return selected, rejected, nil
 }
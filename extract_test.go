@@ -0,0 +1,80 @@
+package artisum
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPromptResultValidate(t *testing.T) {
+	valid := func() *InterestArticle {
+		return &InterestArticle{Title: "Go 1.23", Tag: "golang", URL: "https://go.dev/blog/go1.23", Score: 7, Reason: "release"}
+	}
+	tests := []struct {
+		name   string
+		result *PromptResult
+		want   []string
+	}{
+		{
+			name:   "valid",
+			result: &PromptResult{ExtractedArticles: []*InterestArticle{valid()}, RejectedArticles: []*RejectedArticle{{Title: "t", URL: "u", Reason: "r"}}},
+		},
+		{
+			name:   "nothing selected",
+			result: &PromptResult{ExtractedArticles: []*InterestArticle{}},
+		},
+		{
+			name:   "missing",
+			result: &PromptResult{},
+			want:   []string{`"ExtractedArticles" is missing`},
+		},
+		{
+			name: "invalid articles",
+			result: &PromptResult{
+				ExtractedArticles: []*InterestArticle{
+					nil,
+					{Title: " ", Tag: "", URL: "/relative", Score: 11},
+					func() *InterestArticle { a := valid(); a.Score = 0; return a }(),
+				},
+				RejectedArticles: []*RejectedArticle{nil},
+			},
+			want: []string{
+				"ExtractedArticles[0] is null",
+				"ExtractedArticles[1].Title is empty",
+				"ExtractedArticles[1].Tag is empty",
+				`ExtractedArticles[1].URL "/relative" is not an absolute URL`,
+				"ExtractedArticles[1].Score 11 is not from 1 to 10",
+				"ExtractedArticles[1].Reason is empty",
+				"ExtractedArticles[2].Score 0 is not from 1 to 10",
+				"RejectedArticles[0] is null",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.result.Validate()
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Errorf("err = %v", err)
+				}
+				return
+			}
+			for _, want := range tt.want {
+				if err == nil || !strings.Contains(err.Error(), want) {
+					t.Errorf("err = %v, want %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPromptResultDecode(t *testing.T) {
+	var result PromptResult
+	err := decodeStructuredOutput(`{"ExtractedArticles": [{"Title": "Go", "Tag": "golang", "URL": "https://go.dev/", "Score": "high", "Reason": "r"}]}`, promptResultSchema, &result)
+	if err == nil {
+		t.Error("a score which is not a number is accepted")
+	}
+	err = decodeStructuredOutput(`Here you are: {"ExtractedArticles": [{"Title": "Go", "Tag": "golang", "URL": "https://go.dev/", "Score": 6, "Reason": "r"}]}`, promptResultSchema, &result)
+	if err != nil || len(result.ExtractedArticles) != 1 || result.ExtractedArticles[0].Score != 6 {
+		t.Errorf("result = %+v, err = %v", result, err)
+	}
+}
@@ -1,78 +1,469 @@
 package artisum
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/mmcdole/gofeed"
+	"golang.org/x/sync/errgroup"
 )
 
+const (
+	defaultFeedConcurrency    = 4
+	defaultFeedTimeoutSeconds = 30
+)
+
+type FeedFetchConfig struct {
+	Concurrency    int `json:"concurrency,omitempty"`
+	TimeoutSeconds int `json:"timeoutSeconds,omitempty"`
+	// Offline makes the Feeder read the last cached response of each feed instead of requesting it.
+	Offline bool `json:"offline,omitempty"`
+}
+
+// FeedConfig is an entry of "urls" in the config. A plain string is accepted as a feed with only URL set.
+type FeedConfig struct {
+	URL  string   `json:"url"`
+	Name string   `json:"name,omitempty"`
+	Tags []string `json:"tags,omitempty"`
+	// Weight biases the selection towards the feed's articles. 1 is used when it is not set.
+	Weight   float64 `json:"weight,omitempty"`
+	MaxItems int     `json:"maxItems,omitempty"`
+	Language string  `json:"language,omitempty"`
+	Enabled  *bool   `json:"enabled,omitempty"`
+}
+
+type FeedCache interface {
+	SaveFeedCache(feedUrl string, body []byte) error
+	GetFeedCache(feedUrl string) ([]byte, error)
+}
+
 type Feeder struct {
-	parser   *gofeed.Parser
-	feedUrls []string
-	from     time.Time
-	to       time.Time
+	fetcher  *Fetcher
+	ledger   *Ledger
+	cache    FeedCache
+	states   map[string]*FeedState
+	statesMu sync.Mutex
+	// validators are the ones fetched in this run. They are moved to states by CommitValidators
+	// once the run is completed, so that the items of a failed run are fetched again by the next one.
+	validators  map[string]*FeedValidators
+	feeds       []*FeedConfig
+	concurrency int
+	timeout     time.Duration
+	offline     bool
+	from        time.Time
+	to          time.Time
 }
 
 type Article struct {
 	Title       string
 	Url         string
+	GUID        string
 	Description string
 	Content     string
 	Datetime    time.Time
+	// AllowedTags are the only tags the article can be selected for, set by the Prefilter. Any tag is allowed when it is empty.
+	AllowedTags []string `json:",omitempty"`
+}
+
+// FeedState is persisted between runs to keep track of how each feed has been behaving.
+type FeedState struct {
+	ETag                string     `json:"etag,omitempty"`
+	LastModified        string     `json:"lastModified,omitempty"`
+	ConsecutiveFailures int        `json:"consecutiveFailures"`
+	LastError           string     `json:"lastError,omitempty"`
+	LastFailedAt        *time.Time `json:"lastFailedAt,omitempty"`
+	LastSucceededAt     *time.Time `json:"lastSucceededAt,omitempty"`
+}
+
+// FeedValidators are the validators of a feed response sent back in the next conditional request.
+type FeedValidators struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+}
+
+type FeedFailure struct {
+	FeedURL             string `json:"feedUrl"`
+	Error               string `json:"error"`
+	ConsecutiveFailures int    `json:"consecutiveFailures"`
 }
 
-func NewFeeder(feedUrls []string, from, to time.Time) *Feeder {
+type feedResponse struct {
+	body         []byte
+	etag         string
+	lastModified string
+	notModified  bool
+}
+
+func NewFeeder(feeds []*FeedConfig, conf *FeedFetchConfig, fetcher *Fetcher, ledger *Ledger, states map[string]*FeedState, cache FeedCache, from, to time.Time) *Feeder {
+	if states == nil {
+		states = make(map[string]*FeedState)
+	}
 	return &Feeder{
-		parser:   gofeed.NewParser(),
-		feedUrls: feedUrls,
-		from:     from,
-		to:       to,
+		fetcher:     fetcher,
+		ledger:      ledger,
+		cache:       cache,
+		states:      states,
+		validators:  make(map[string]*FeedValidators),
+		feeds:       feeds,
+		concurrency: conf.Concurrency,
+		timeout:     time.Duration(conf.TimeoutSeconds) * time.Second,
+		offline:     conf.Offline,
+		from:        from,
+		to:          to,
+	}
+}
+
+// ToArticlesMap fetches every feed concurrently. A feed that cannot be fetched is reported as a FeedFailure
+// and the others are still returned; an error is returned only when no feed could be fetched.
+func (e *Feeder) ToArticlesMap(ctx context.Context) (map[string][]*Article, []*FeedFailure, error) {
+	var (
+		mu          sync.Mutex
+		articlesMap = make(map[string][]*Article)
+		failures    []*FeedFailure
+	)
+
+	var (
+		eg       errgroup.Group
+		numFeeds int
+	)
+	eg.SetLimit(e.concurrency)
+	for _, feed := range e.feeds {
+		if !feed.IsEnabled() {
+			continue
+		}
+		numFeeds++
+		feedUrl := feed.URL
+		eg.Go(func() error {
+			articles, err := e.fetch(ctx, feedUrl)
+			articles = limitArticles(articles, feed.MaxItems)
+			if e.offline {
+				if err != nil {
+					slog.Warn("failed to read cached feed", slog.String("url", feedUrl), slog.String("error", err.Error()))
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				articlesMap[feedUrl] = articles
+				return nil
+			}
+			state := e.recordResult(feedUrl, err)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				slog.Warn("failed to fetch feed", slog.String("url", feedUrl), slog.String("error", err.Error()), slog.Int("consecutiveFailures", state.ConsecutiveFailures))
+				failures = append(failures, &FeedFailure{
+					FeedURL:             feedUrl,
+					Error:               err.Error(),
+					ConsecutiveFailures: state.ConsecutiveFailures,
+				})
+				return nil
+			}
+			articlesMap[feedUrl] = articles
+			return nil
+		})
+	}
+	_ = eg.Wait()
+
+	if numFeeds > 0 && len(failures) == numFeeds {
+		return nil, failures, errors.New("failed to fetch all feeds")
 	}
+	return articlesMap, failures, nil
 }
 
-func (e *Feeder) ToArticlesMap() (map[string][]*Article, error) {
-	var articlesMap = make(map[string][]*Article)
-	for _, feedUrl := range e.feedUrls {
-		feed, err := e.parser.ParseURL(feedUrl)
+func (e *Feeder) States() map[string]*FeedState {
+	e.statesMu.Lock()
+	defer e.statesMu.Unlock()
+
+	states := make(map[string]*FeedState, len(e.states))
+	for feedUrl, state := range e.states {
+		copied := *state
+		states[feedUrl] = &copied
+	}
+	return states
+}
+
+// Validators returns the validators of the feeds fetched in this run, which are not committed yet.
+func (e *Feeder) Validators() map[string]*FeedValidators {
+	e.statesMu.Lock()
+	defer e.statesMu.Unlock()
+
+	validators := make(map[string]*FeedValidators, len(e.validators))
+	for feedUrl, v := range e.validators {
+		copied := *v
+		validators[feedUrl] = &copied
+	}
+	return validators
+}
+
+// CommitValidators makes the next conditional requests use validators.
+func (e *Feeder) CommitValidators(validators map[string]*FeedValidators) {
+	e.statesMu.Lock()
+	defer e.statesMu.Unlock()
+
+	for feedUrl, v := range validators {
+		state, ok := e.states[feedUrl]
+		if !ok {
+			state = &FeedState{}
+			e.states[feedUrl] = state
+		}
+		state.ETag = v.ETag
+		state.LastModified = v.LastModified
+	}
+}
+
+func (e *Feeder) fetch(ctx context.Context, feedUrl string) ([]*Article, error) {
+	var (
+		body []byte
+		resp *feedResponse
+	)
+	if e.offline {
+		cached, err := e.cache.GetFeedCache(feedUrl)
 		if err != nil {
 			return nil, err
 		}
-		if feed == nil {
+		body = cached
+	} else {
+		var err error
+		resp, err = e.request(ctx, feedUrl)
+		if err != nil {
+			return nil, err
+		}
+		if resp.notModified {
+			slog.Info("feed is not modified", slog.String("url", feedUrl))
+			return nil, nil
+		}
+		if err := e.cache.SaveFeedCache(feedUrl, resp.body); err != nil {
+			slog.Warn("failed to cache feed", slog.String("url", feedUrl), slog.String("error", err.Error()))
+		}
+		body = resp.body
+	}
+
+	// gofeed.Parser keeps state while parsing, so it can not be shared between goroutines.
+	feed, err := gofeed.NewParser().Parse(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if resp != nil {
+		// validators are kept only after a successful parse so that a broken response is fetched again next time.
+		e.recordValidators(feedUrl, resp)
+	}
+	if feed == nil {
+		return nil, nil
+	}
+
+	lastModified := e.lastModified(feedUrl, resp)
+
+	var articles []*Article
+	for _, item := range feed.Items {
+		article := &Article{
+			Title:       item.Title,
+			Url:         item.Link,
+			GUID:        item.GUID,
+			Description: item.Description,
+			Content:     item.Content,
+		}
+		article.Datetime = e.effectiveDatetime(item, article, lastModified)
+		if !e.isWithinRange(article.Datetime) {
 			continue
 		}
 
-		var articles []*Article
-		for _, item := range feed.Items {
-			if !e.isWithinRange(item) {
-				continue
-			}
+		if e.ledger.IsSummarized(article) {
+			slog.Info("skip already summarized article", slog.String("url", article.Url))
+			continue
+		}
+		e.ledger.Seen(article, e.to)
 
-			articles = append(articles, &Article{
-				Title:       item.Title,
-				Url:         item.Link,
-				Description: item.Description,
-				Content:     item.Content,
-				Datetime:    *item.PublishedParsed,
-			})
+		articles = append(articles, article)
+	}
+	return articles, nil
+}
+
+// request sends a conditional GET using the validators stored from the previous run.
+func (e *Feeder) request(ctx context.Context, feedUrl string) (*feedResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+
+	header := make(http.Header)
+	e.statesMu.Lock()
+	if state, ok := e.states[feedUrl]; ok {
+		if state.ETag != "" {
+			header.Set("If-None-Match", state.ETag)
+		}
+		if state.LastModified != "" {
+			header.Set("If-Modified-Since", state.LastModified)
 		}
-		articlesMap[feedUrl] = articles
 	}
+	e.statesMu.Unlock()
 
-	return articlesMap, nil
+	resp, err := e.fetcher.Fetch(ctx, feedUrl, &FetchOptions{Header: header})
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotModified {
+		return &feedResponse{notModified: true}, nil
+	}
+
+	return &feedResponse{
+		body:         resp.Body,
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+	}, nil
 }
 
-func (e *Feeder) isWithinRange(item *gofeed.Item) bool {
-	publishDatetime := item.PublishedParsed
-	if publishDatetime == nil {
-		return false
+func (e *Feeder) recordValidators(feedUrl string, resp *feedResponse) {
+	e.statesMu.Lock()
+	defer e.statesMu.Unlock()
+
+	e.validators[feedUrl] = &FeedValidators{ETag: resp.etag, LastModified: resp.lastModified}
+}
+
+func (e *Feeder) recordResult(feedUrl string, err error) FeedState {
+	e.statesMu.Lock()
+	defer e.statesMu.Unlock()
+
+	state, ok := e.states[feedUrl]
+	if !ok {
+		state = &FeedState{}
+		e.states[feedUrl] = state
+	}
+
+	now := e.to
+	if err != nil {
+		state.ConsecutiveFailures++
+		state.LastError = err.Error()
+		state.LastFailedAt = &now
+	} else {
+		state.ConsecutiveFailures = 0
+		state.LastError = ""
+		state.LastSucceededAt = &now
+	}
+	return *state
+}
+
+// effectiveDatetime falls back to the updated date, the first time the item was seen,
+// and the feed's Last-Modified header in that order when the item has no published date.
+// An item seen for the first time without any date is dated at this run.
+func (e *Feeder) effectiveDatetime(item *gofeed.Item, article *Article, lastModified time.Time) time.Time {
+	if item.PublishedParsed != nil {
+		return *item.PublishedParsed
+	}
+	if item.UpdatedParsed != nil {
+		return *item.UpdatedParsed
+	}
+	if firstSeenAt, ok := e.ledger.FirstSeenAt(article); ok {
+		return firstSeenAt
+	}
+	if !lastModified.IsZero() {
+		return lastModified
+	}
+	return e.to
+}
+
+func (e *Feeder) lastModified(feedUrl string, resp *feedResponse) time.Time {
+	var value string
+	if resp != nil {
+		value = resp.lastModified
+	} else {
+		e.statesMu.Lock()
+		if state, ok := e.states[feedUrl]; ok {
+			value = state.LastModified
+		}
+		e.statesMu.Unlock()
+	}
+	if value == "" {
+		return time.Time{}
 	}
 
-	if !e.from.IsZero() && publishDatetime.Before(e.from) {
+	t, err := http.ParseTime(value)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+func (e *Feeder) isWithinRange(datetime time.Time) bool {
+	if !e.from.IsZero() && datetime.Before(e.from) {
 		return false
 	}
-	if !e.to.IsZero() && publishDatetime.After(e.to) {
+	if !e.to.IsZero() && datetime.After(e.to) {
 		return false
 	}
 
 	return true
 }
+
+// limitArticles keeps the newest maxItems articles. maxItems <= 0 means no limit.
+func limitArticles(articles []*Article, maxItems int) []*Article {
+	if maxItems <= 0 || len(articles) <= maxItems {
+		return articles
+	}
+	sort.SliceStable(articles, func(i, j int) bool {
+		return articles[i].Datetime.After(articles[j].Datetime)
+	})
+	return articles[:maxItems]
+}
+
+func (f *FeedConfig) IsEnabled() bool {
+	return f.Enabled == nil || *f.Enabled
+}
+
+func (f *FeedConfig) weight() float64 {
+	if f.Weight <= 0 {
+		return 1
+	}
+	return f.Weight
+}
+
+func (f *FeedConfig) UnmarshalJSON(b []byte) error {
+	var u string
+	if err := json.Unmarshal(b, &u); err == nil {
+		*f = FeedConfig{URL: u}
+		return nil
+	}
+
+	type feedConfig FeedConfig
+	var c feedConfig
+	if err := json.Unmarshal(b, &c); err != nil {
+		return err
+	}
+	*f = FeedConfig(c)
+	return nil
+}
+
+// MarshalJSON writes a feed with only URL set as a plain string to keep the config file in its simple form.
+// The URL is not HTML-escaped, so that the query of a URL stays readable in the config file.
+func (f *FeedConfig) MarshalJSON() ([]byte, error) {
+	type feedConfig FeedConfig
+	var v any = (*feedConfig)(f)
+	if f.isURLOnly() {
+		v = f.URL
+	}
+
+	var b bytes.Buffer
+	encoder := json.NewEncoder(&b)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(b.Bytes(), []byte("\n")), nil
+}
+
+func (f *FeedConfig) isURLOnly() bool {
+	return f.Name == "" && len(f.Tags) == 0 && f.Weight == 0 && f.MaxItems == 0 && f.Language == "" && f.Enabled == nil
+}
+
+func (c *FeedFetchConfig) setDefaults() {
+	if c.Concurrency <= 0 {
+		c.Concurrency = defaultFeedConcurrency
+	}
+	if c.TimeoutSeconds <= 0 {
+		c.TimeoutSeconds = defaultFeedTimeoutSeconds
+	}
+}
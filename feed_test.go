@@ -0,0 +1,275 @@
+package artisum
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+const testRSS = `<?xml version="1.0"?>
+<rss version="2.0"><channel><title>test</title>
+<item><title>first</title><link>https://example.com/1</link><pubDate>Wed, 01 May 2024 09:00:00 GMT</pubDate></item>
+<item><title>second</title><link>https://example.com/2</link><pubDate>Wed, 01 May 2024 10:00:00 GMT</pubDate></item>
+</channel></rss>`
+
+// memoryFeedCache is safe for the concurrent fetches of the feeds.
+type memoryFeedCache struct {
+	bodies sync.Map
+}
+
+func (c *memoryFeedCache) SaveFeedCache(feedUrl string, body []byte) error {
+	c.bodies.Store(feedUrl, body)
+	return nil
+}
+
+func (c *memoryFeedCache) GetFeedCache(feedUrl string) ([]byte, error) {
+	body, _ := c.bodies.Load(feedUrl)
+	b, _ := body.([]byte)
+	return b, nil
+}
+
+func newTestFetcher() *Fetcher {
+	conf := &FetcherConfig{MaxRetries: -1}
+	conf.setDefaults()
+	return NewFetcher(conf)
+}
+
+func TestFeederConditionalGet(t *testing.T) {
+	var ifNoneMatch []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ifNoneMatch = append(ifNoneMatch, r.Header.Get("If-None-Match"))
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Last-Modified", "Wed, 01 May 2024 10:00:00 GMT")
+		_, _ = io.WriteString(w, testRSS)
+	}))
+	defer server.Close()
+
+	from := time.Date(2024, 4, 30, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC)
+	feeds := []*FeedConfig{{URL: server.URL}}
+	conf := &FeedFetchConfig{}
+	conf.setDefaults()
+	feeder := NewFeeder(feeds, conf, newTestFetcher(), NewLedger(nil), nil, &memoryFeedCache{}, from, to)
+
+	articles, failures, err := feeder.ToArticlesMap(context.Background())
+	if err != nil || len(failures) > 0 {
+		t.Fatalf("err = %v, failures = %v", err, failures)
+	}
+	if got := len(articles[server.URL]); got != 2 {
+		t.Fatalf("got %d articles, want 2", got)
+	}
+	if state := feeder.States()[server.URL]; state.ETag != "" {
+		t.Errorf("the validators are committed before the run is completed: %+v", state)
+	}
+	validators := feeder.Validators()
+	if v := validators[server.URL]; v == nil || v.ETag != `"v1"` {
+		t.Fatalf("validators = %+v", validators)
+	}
+
+	// The next run of an uncompleted one requests the feed unconditionally.
+	feeder = NewFeeder(feeds, conf, newTestFetcher(), NewLedger(nil), feeder.States(), &memoryFeedCache{}, from, to)
+	if articles, _, _ := feeder.ToArticlesMap(context.Background()); len(articles[server.URL]) != 2 {
+		t.Errorf("got %d articles after an uncompleted run, want 2", len(articles[server.URL]))
+	}
+
+	feeder.CommitValidators(validators)
+	articles, failures, err = feeder.ToArticlesMap(context.Background())
+	if err != nil || len(failures) > 0 {
+		t.Fatalf("err = %v, failures = %v", err, failures)
+	}
+	if _, ok := articles[server.URL]; !ok || len(articles[server.URL]) != 0 {
+		t.Errorf("got %v for a not modified feed", articles)
+	}
+	if (&Run{FeedArticles: articles}).hasFeedArticles() {
+		t.Error("a run of not modified feeds has articles")
+	}
+	if want := []string{"", "", `"v1"`}; !slices.Equal(ifNoneMatch, want) {
+		t.Errorf("If-None-Match = %q, want %q", ifNoneMatch, want)
+	}
+}
+
+func TestFeederOffline(t *testing.T) {
+	cache := &memoryFeedCache{}
+	_ = cache.SaveFeedCache("https://example.com/feed", []byte(testRSS))
+	conf := &FeedFetchConfig{Offline: true}
+	conf.setDefaults()
+	feeder := NewFeeder([]*FeedConfig{{URL: "https://example.com/feed"}}, conf, newTestFetcher(), NewLedger(nil), nil, cache, time.Time{}, time.Time{})
+
+	articles, _, err := feeder.ToArticlesMap(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(articles["https://example.com/feed"]); got != 2 {
+		t.Errorf("got %d articles from the cache, want 2", got)
+	}
+	if len(feeder.States()) != 0 || len(feeder.Validators()) != 0 {
+		t.Error("an offline fetch changed the feed states")
+	}
+}
+
+func TestFeederFailures(t *testing.T) {
+	var (
+		mu        sync.Mutex
+		broken    = true
+		requested = make(map[string]int)
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requested[r.URL.Path]++
+		if r.URL.Path == "/broken" && broken {
+			http.Error(w, "broken", http.StatusInternalServerError)
+			return
+		}
+		_, _ = io.WriteString(w, testRSS)
+	}))
+	defer server.Close()
+
+	disabled := false
+	feeds := []*FeedConfig{
+		{URL: server.URL + "/good"},
+		{URL: server.URL + "/broken"},
+		{URL: server.URL + "/disabled", Enabled: &disabled},
+	}
+	conf := &FeedFetchConfig{}
+	conf.setDefaults()
+	run := func(states map[string]*FeedState) (*Feeder, map[string][]*Article, []*FeedFailure) {
+		t.Helper()
+		feeder := NewFeeder(feeds, conf, newTestFetcher(), NewLedger(nil), states, &memoryFeedCache{}, time.Time{}, time.Time{})
+		articles, failures, err := feeder.ToArticlesMap(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return feeder, articles, failures
+	}
+
+	feeder, articles, failures := run(nil)
+	if got := len(articles[server.URL+"/good"]); got != 2 {
+		t.Errorf("got %d articles of the working feed, want 2", got)
+	}
+	if _, ok := articles[server.URL+"/broken"]; ok {
+		t.Errorf("got articles of the broken feed: %v", articles)
+	}
+	if len(failures) != 1 || failures[0].FeedURL != server.URL+"/broken" || failures[0].ConsecutiveFailures != 1 || failures[0].Error == "" {
+		t.Fatalf("failures = %+v", failures)
+	}
+
+	feeder, _, failures = run(feeder.States())
+	if len(failures) != 1 || failures[0].ConsecutiveFailures != 2 {
+		t.Fatalf("failures = %+v, want the second consecutive failure", failures)
+	}
+	if state := feeder.States()[server.URL+"/broken"]; state.LastError == "" || state.LastFailedAt == nil {
+		t.Errorf("state = %+v", state)
+	}
+
+	mu.Lock()
+	broken = false
+	mu.Unlock()
+	feeder, articles, failures = run(feeder.States())
+	if len(failures) != 0 || len(articles[server.URL+"/broken"]) != 2 {
+		t.Fatalf("failures = %+v, articles = %v", failures, articles)
+	}
+	if state := feeder.States()[server.URL+"/broken"]; state.ConsecutiveFailures != 0 || state.LastError != "" || state.LastSucceededAt == nil {
+		t.Errorf("state = %+v, want it reset by the success", state)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if n := requested["/disabled"]; n != 0 {
+		t.Errorf("the disabled feed is fetched %d times", n)
+	}
+}
+
+func TestFeederAllFeedsFail(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "broken", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	feeds := []*FeedConfig{{URL: server.URL + "/a"}, {URL: server.URL + "/b"}}
+	conf := &FeedFetchConfig{}
+	conf.setDefaults()
+	feeder := NewFeeder(feeds, conf, newTestFetcher(), NewLedger(nil), nil, &memoryFeedCache{}, time.Time{}, time.Time{})
+	articles, failures, err := feeder.ToArticlesMap(context.Background())
+	if err == nil {
+		t.Fatalf("got %v without an error", articles)
+	}
+	if len(failures) != 2 {
+		t.Errorf("failures = %+v", failures)
+	}
+}
+
+func TestFeederEffectiveDatetime(t *testing.T) {
+	var (
+		published    = time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+		updated      = time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+		firstSeen    = time.Date(2024, 5, 1, 11, 0, 0, 0, time.UTC)
+		lastModified = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+		now          = time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC)
+	)
+	ledger := NewLedger([]*LedgerEntry{{URL: "https://example.com/seen", FirstSeenAt: firstSeen}})
+	conf := &FeedFetchConfig{}
+	conf.setDefaults()
+	feeder := NewFeeder(nil, conf, newTestFetcher(), ledger, nil, &memoryFeedCache{}, time.Time{}, now)
+
+	tests := []struct {
+		name         string
+		item         *gofeed.Item
+		url          string
+		lastModified time.Time
+		want         time.Time
+	}{
+		{
+			name:         "published",
+			item:         &gofeed.Item{PublishedParsed: &published, UpdatedParsed: &updated},
+			url:          "https://example.com/seen",
+			lastModified: lastModified,
+			want:         published,
+		},
+		{
+			name:         "no published date",
+			item:         &gofeed.Item{UpdatedParsed: &updated},
+			url:          "https://example.com/seen",
+			lastModified: lastModified,
+			want:         updated,
+		},
+		{
+			name:         "no updated date",
+			item:         &gofeed.Item{},
+			url:          "https://example.com/seen/",
+			lastModified: lastModified,
+			want:         firstSeen,
+		},
+		{
+			name:         "not seen before",
+			item:         &gofeed.Item{},
+			url:          "https://example.com/new",
+			lastModified: lastModified,
+			want:         lastModified,
+		},
+		{
+			name: "no Last-Modified",
+			item: &gofeed.Item{},
+			url:  "https://example.com/new",
+			want: now,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := feeder.effectiveDatetime(tt.item, &Article{Url: tt.url}, tt.lastModified)
+			if !got.Equal(tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,423 @@
+package artisum
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/html/charset"
+)
+
+const (
+	defaultUserAgent           = "artisum/1.0 (+https://github.com/kazdevl/artisum)"
+	defaultFetchTimeoutSeconds = 30
+	defaultMaxBodyBytes        = 10 << 20
+	defaultMaxRetries          = 2
+	fetchRetryBaseDelay        = time.Second
+	fetchRetryMaxDelay         = 30 * time.Second
+)
+
+var (
+	ErrDisallowedByRobots     = errors.New("disallowed by robots.txt")
+	ErrUnsupportedContentType = errors.New("unsupported content type")
+	ErrBodyTooLarge           = errors.New("response body is too large")
+)
+
+type FetcherConfig struct {
+	UserAgent      string `json:"userAgent,omitempty"`
+	TimeoutSeconds int    `json:"timeoutSeconds,omitempty"`
+	MaxBodyBytes   int64  `json:"maxBodyBytes,omitempty"`
+	// MaxRetries is the number of retries for transient failures. -1 disables retrying.
+	MaxRetries   int  `json:"maxRetries,omitempty"`
+	IgnoreRobots bool `json:"ignoreRobots,omitempty"`
+}
+
+// Fetcher is the HTTP client shared by the Feeder and the content loader.
+type Fetcher struct {
+	client       *http.Client
+	userAgent    string
+	maxBodyBytes int64
+	maxRetries   int
+	ignoreRobots bool
+	robotsMu     sync.Mutex
+	robots       map[string]*robotsEntry
+}
+
+type FetchOptions struct {
+	Header http.Header
+	// AcceptTypes are the media types the caller can handle. Any type is accepted when it is empty.
+	AcceptTypes []string
+	// CheckRobots rejects the URL when robots.txt of the host disallows it.
+	CheckRobots bool
+	// DecodeCharset converts a text body to UTF-8 using the declared charset.
+	DecodeCharset bool
+}
+
+type FetchResponse struct {
+	// URL is the final URL after redirects.
+	URL        *url.URL
+	StatusCode int
+	Header     http.Header
+	// MediaType is sniffed from the body when the response has no Content-Type.
+	MediaType string
+	Body      []byte
+}
+
+type HTTPStatusError struct {
+	URL        string
+	StatusCode int
+	Status     string
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("http error: %s (%s)", e.Status, e.URL)
+}
+
+func NewFetcher(conf *FetcherConfig) *Fetcher {
+	return &Fetcher{
+		client:       &http.Client{Timeout: time.Duration(conf.TimeoutSeconds) * time.Second},
+		userAgent:    conf.UserAgent,
+		maxBodyBytes: conf.MaxBodyBytes,
+		maxRetries:   conf.MaxRetries,
+		ignoreRobots: conf.IgnoreRobots,
+		robots:       make(map[string]*robotsEntry),
+	}
+}
+
+// Fetch GETs rawURL, retrying transient failures with exponential backoff.
+// A non-2xx response is returned as *HTTPStatusError, except 304 which is returned as is for conditional requests.
+func (f *Fetcher) Fetch(ctx context.Context, rawURL string, opts *FetchOptions) (*FetchResponse, error) {
+	if opts == nil {
+		opts = &FetchOptions{}
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if opts.CheckRobots {
+		if err := f.checkRobots(ctx, u); err != nil {
+			return nil, err
+		}
+	}
+
+	var resp *FetchResponse
+	for attempt := 0; ; attempt++ {
+		var retryAfter time.Duration
+		resp, retryAfter, err = f.fetchOnce(ctx, rawURL, opts)
+		if err == nil || attempt >= f.maxRetries || !isTransient(ctx, err) {
+			break
+		}
+
+		delay := fetchRetryBaseDelay << attempt
+		if retryAfter > delay {
+			delay = retryAfter
+		}
+		delay = min(delay, fetchRetryMaxDelay)
+		slog.Info("retry fetching", slog.String("url", rawURL), slog.Int("attempt", attempt+1), slog.Duration("delay", delay), slog.String("error", err.Error()))
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotModified {
+		return resp, nil
+	}
+	if len(opts.AcceptTypes) > 0 && !isAcceptedType(resp.MediaType, opts.AcceptTypes) {
+		return nil, fmt.Errorf("%w: %s (%s)", ErrUnsupportedContentType, resp.MediaType, rawURL)
+	}
+	if opts.DecodeCharset && isTextType(resp.MediaType) {
+		decoded, err := decodeCharset(resp.Body, resp.Header.Get("Content-Type"))
+		if err != nil {
+			return nil, err
+		}
+		resp.Body = decoded
+	}
+	return resp, nil
+}
+
+func (f *Fetcher) checkRobots(ctx context.Context, u *url.URL) error {
+	if f.ignoreRobots {
+		return nil
+	}
+	rules, err := f.robotsRulesFor(ctx, u)
+	if err != nil {
+		return err
+	}
+	if rules.allowed(u) {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrDisallowedByRobots, u)
+}
+
+func (f *Fetcher) fetchOnce(ctx context.Context, rawURL string, opts *FetchOptions) (*FetchResponse, time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	for key, values := range opts.Header {
+		for _, v := range values {
+			req.Header.Add(key, v)
+		}
+	}
+	req.Header.Set("User-Agent", f.userAgent)
+	if len(opts.AcceptTypes) > 0 && req.Header.Get("Accept") == "" {
+		req.Header.Set("Accept", strings.Join(opts.AcceptTypes, ", ")+", */*;q=0.1")
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	result := &FetchResponse{
+		URL:        resp.Request.URL,
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		MediaType:  mediaType,
+	}
+	if resp.StatusCode == http.StatusNotModified {
+		return result, 0, nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, parseRetryAfter(resp.Header.Get("Retry-After")), &HTTPStatusError{
+			URL:        rawURL,
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+		}
+	}
+	if resp.ContentLength > f.maxBodyBytes {
+		return nil, 0, fmt.Errorf("%w: %d bytes (%s)", ErrBodyTooLarge, resp.ContentLength, rawURL)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, f.maxBodyBytes+1))
+	if err != nil {
+		return nil, 0, err
+	}
+	if int64(len(body)) > f.maxBodyBytes {
+		return nil, 0, fmt.Errorf("%w: over %d bytes (%s)", ErrBodyTooLarge, f.maxBodyBytes, rawURL)
+	}
+	result.Body = body
+	if result.MediaType == "" {
+		result.MediaType, _, _ = mime.ParseMediaType(http.DetectContentType(body))
+	}
+	return result, 0, nil
+}
+
+func isTransient(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var statusErr *HTTPStatusError
+	if errors.As(err, &statusErr) {
+		switch statusErr.StatusCode {
+		case http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusInternalServerError,
+			http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	return !errors.Is(err, ErrBodyTooLarge)
+}
+
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(v); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+func isAcceptedType(mediaType string, acceptTypes []string) bool {
+	for _, t := range acceptTypes {
+		if t == mediaType || (strings.HasSuffix(t, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(t, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+func isTextType(mediaType string) bool {
+	return strings.HasPrefix(mediaType, "text/") || mediaType == "application/xhtml+xml"
+}
+
+// decodeCharset uses the charset in Content-Type, a BOM or a <meta> declaration in that order, e.g. for Shift_JIS or EUC-JP pages.
+// contentType is the header of the response, not a sniffed type, which would always declare UTF-8.
+func decodeCharset(body []byte, contentType string) ([]byte, error) {
+	r, err := charset.NewReader(bytes.NewReader(body), contentType)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+type robotsRules struct {
+	rules []robotsRule
+}
+
+type robotsRule struct {
+	allow   bool
+	pattern string
+}
+
+// robotsEntry is the robots.txt of a host. rules is set when ready is closed.
+type robotsEntry struct {
+	ready chan struct{}
+	rules *robotsRules
+}
+
+// robotsRulesFor returns the cached rules of the host. Only the first caller for a host fetches robots.txt,
+// the others wait for it, so that a slow host does not hold up the fetches to other hosts.
+func (f *Fetcher) robotsRulesFor(ctx context.Context, u *url.URL) (*robotsRules, error) {
+	key := u.Scheme + "://" + u.Host
+
+	f.robotsMu.Lock()
+	entry, ok := f.robots[key]
+	if !ok {
+		entry = &robotsEntry{ready: make(chan struct{})}
+		f.robots[key] = entry
+	}
+	f.robotsMu.Unlock()
+
+	if ok {
+		select {
+		case <-entry.ready:
+			return entry.rules, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	// robots.txt which can not be read is treated as allowing everything.
+	rules := &robotsRules{}
+	resp, _, err := f.fetchOnce(ctx, key+"/robots.txt", &FetchOptions{})
+	if err == nil && resp.StatusCode == http.StatusOK {
+		rules = parseRobots(resp.Body, f.userAgent)
+	}
+	entry.rules = rules
+	close(entry.ready)
+	return rules, nil
+}
+
+// parseRobots reads the group for userAgent, or "*" when there is no specific group.
+func parseRobots(body []byte, userAgent string) *robotsRules {
+	product := strings.ToLower(strings.SplitN(userAgent, "/", 2)[0])
+
+	var (
+		specific, wildcard []robotsRule
+		agents             []string
+		inRules            bool
+		hasSpecific        bool
+	)
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "user-agent":
+			if inRules {
+				agents = nil
+				inRules = false
+			}
+			agents = append(agents, strings.ToLower(value))
+		case "allow", "disallow":
+			inRules = true
+			if value == "" {
+				continue
+			}
+			rule := robotsRule{allow: key == "allow", pattern: value}
+			for _, agent := range agents {
+				switch {
+				case agent == "*":
+					wildcard = append(wildcard, rule)
+				case product != "" && strings.Contains(product, agent):
+					hasSpecific = true
+					specific = append(specific, rule)
+				}
+			}
+		}
+	}
+
+	if hasSpecific {
+		return &robotsRules{rules: specific}
+	}
+	return &robotsRules{rules: wildcard}
+}
+
+// allowed applies the most specific (longest) matching rule. Allow wins a tie.
+func (r *robotsRules) allowed(u *url.URL) bool {
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+
+	allowed, matched := true, -1
+	for _, rule := range r.rules {
+		if !matchRobotsPattern(rule.pattern, path) {
+			continue
+		}
+		if len(rule.pattern) > matched || (len(rule.pattern) == matched && rule.allow) {
+			allowed, matched = rule.allow, len(rule.pattern)
+		}
+	}
+	return allowed
+}
+
+func matchRobotsPattern(pattern, path string) bool {
+	expr := "^" + strings.ReplaceAll(regexp.QuoteMeta(strings.TrimSuffix(pattern, "$")), `\*`, ".*")
+	if strings.HasSuffix(pattern, "$") {
+		expr += "$"
+	}
+	matched, err := regexp.MatchString(expr, path)
+	return err == nil && matched
+}
+
+func (c *FetcherConfig) setDefaults() {
+	if c.UserAgent == "" {
+		c.UserAgent = defaultUserAgent
+	}
+	if c.TimeoutSeconds <= 0 {
+		c.TimeoutSeconds = defaultFetchTimeoutSeconds
+	}
+	if c.MaxBodyBytes <= 0 {
+		c.MaxBodyBytes = defaultMaxBodyBytes
+	}
+	if c.MaxRetries == 0 {
+		c.MaxRetries = defaultMaxRetries
+	} else if c.MaxRetries < 0 {
+		c.MaxRetries = 0
+	}
+}
@@ -0,0 +1,227 @@
+package artisum
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseRobots(t *testing.T) {
+	body := []byte(`# comment
+User-agent: *
+Disallow: /private/
+Allow: /private/public
+Disallow: /*.pdf$
+
+User-agent: Googlebot
+User-agent: artisum
+Disallow: /no-artisum # only for us
+Allow: /no-artisum/ok
+
+User-agent: otherbot
+Disallow: /
+`)
+	tests := []struct {
+		userAgent string
+		path      string
+		want      bool
+	}{
+		{"artisum/1.0", "/private/page", true},
+		{"artisum/1.0", "/no-artisum", false},
+		{"artisum/1.0", "/no-artisum/ok", true},
+		{"somebot/2.0", "/", true},
+		{"somebot/2.0", "/private/page", false},
+		{"somebot/2.0", "/private/public/page", true},
+		{"somebot/2.0", "/docs/spec.pdf", false},
+		{"somebot/2.0", "/docs/spec.pdf?download=1", true},
+		{"otherbot", "/anything", false},
+	}
+	for _, tt := range tests {
+		u, _ := url.Parse("https://example.com" + tt.path)
+		if got := parseRobots(body, tt.userAgent).allowed(u); got != tt.want {
+			t.Errorf("%s %s: got %v, want %v", tt.userAgent, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestFetcherRobots(t *testing.T) {
+	var robotsRequests atomic.Int32
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			robotsRequests.Add(1)
+			<-release
+			_, _ = io.WriteString(w, "User-agent: *\nDisallow: /private\n")
+			return
+		}
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer slow.Close()
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer fast.Close()
+
+	fetcher := newTestFetcher()
+	ctx := context.Background()
+	opts := &FetchOptions{CheckRobots: true}
+
+	var (
+		wg   sync.WaitGroup
+		errs = make([]error, 4)
+	)
+	for i, path := range []string{"/a", "/b", "/private/c", "/d"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = fetcher.Fetch(ctx, slow.URL+path, opts)
+		}()
+	}
+
+	// A host whose robots.txt is slow does not hold up the other hosts.
+	done := make(chan error, 1)
+	go func() {
+		_, err := fetcher.Fetch(ctx, fast.URL+"/page", opts)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("fetch from the other host: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("fetch from the other host waited for the slow robots.txt")
+	}
+
+	close(release)
+	wg.Wait()
+	if n := robotsRequests.Load(); n != 1 {
+		t.Errorf("robots.txt was requested %d times, want 1", n)
+	}
+	for i, err := range errs {
+		if wantDisallowed := i == 2; errors.Is(err, ErrDisallowedByRobots) != wantDisallowed || (!wantDisallowed && err != nil) {
+			t.Errorf("fetch %d: %v", i, err)
+		}
+	}
+}
+
+func TestFetcherConditionalGet(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"etag"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"etag"`)
+		_, _ = io.WriteString(w, "body")
+	}))
+	defer server.Close()
+
+	fetcher := newTestFetcher()
+	resp, err := fetcher.Fetch(context.Background(), server.URL, nil)
+	if err != nil || resp.StatusCode != http.StatusOK || string(resp.Body) != "body" {
+		t.Fatalf("resp = %+v, err = %v", resp, err)
+	}
+	resp, err = fetcher.Fetch(context.Background(), server.URL, &FetchOptions{Header: http.Header{"If-None-Match": {resp.Header.Get("ETag")}}})
+	if err != nil || resp.StatusCode != http.StatusNotModified {
+		t.Fatalf("resp = %+v, err = %v", resp, err)
+	}
+}
+
+func TestFetcherErrors(t *testing.T) {
+	var attempts atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/unavailable", func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = io.WriteString(w, "ok")
+	})
+	mux.HandleFunc("/missing", http.NotFound)
+	mux.HandleFunc("/large", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(make([]byte, 2048))
+	})
+	mux.HandleFunc("/sjis", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=shift_jis")
+		// "日本語" in Shift_JIS.
+		_, _ = w.Write([]byte{0x93, 0xfa, 0x96, 0x7b, 0x8c, 0xea})
+	})
+	mux.HandleFunc("/image", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	conf := &FetcherConfig{MaxBodyBytes: 1024, MaxRetries: 1}
+	conf.setDefaults()
+	fetcher := NewFetcher(conf)
+	ctx := context.Background()
+
+	if resp, err := fetcher.Fetch(ctx, server.URL+"/unavailable", nil); err != nil || string(resp.Body) != "ok" {
+		t.Errorf("retry: resp = %v, err = %v", resp, err)
+	}
+	var statusErr *HTTPStatusError
+	if _, err := fetcher.Fetch(ctx, server.URL+"/missing", nil); !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusNotFound {
+		t.Errorf("missing: err = %v", err)
+	}
+	if _, err := fetcher.Fetch(ctx, server.URL+"/large", nil); !errors.Is(err, ErrBodyTooLarge) {
+		t.Errorf("large: err = %v", err)
+	}
+	if resp, err := fetcher.Fetch(ctx, server.URL+"/sjis", &FetchOptions{DecodeCharset: true}); err != nil || string(resp.Body) != "日本語" {
+		t.Errorf("charset: resp = %v, err = %v", resp, err)
+	}
+	if _, err := fetcher.Fetch(ctx, server.URL+"/image", &FetchOptions{AcceptTypes: []string{"text/*"}}); !errors.Is(err, ErrUnsupportedContentType) {
+		t.Errorf("content type: err = %v", err)
+	}
+}
+
+func TestFetcherWithoutContentType(t *testing.T) {
+	pdf := []byte("%PDF-1.7\n\x93\xfa\x96\x7b")
+	binary := []byte{0x00, 0x01, 0x93, 0xfa}
+	// "日本語" in Shift_JIS, declared only by <meta>.
+	sjis := append([]byte(`<html><head><meta charset="shift_jis"></head><body>`), 0x93, 0xfa, 0x96, 0x7b, 0x8c, 0xea)
+	mux := http.NewServeMux()
+	for path, body := range map[string][]byte{"/pdf": pdf, "/binary": binary, "/sjis": sjis} {
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			// The server would sniff the type otherwise.
+			w.Header()["Content-Type"] = nil
+			_, _ = w.Write(body)
+		})
+	}
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	fetcher := newTestFetcher()
+	opts := &FetchOptions{AcceptTypes: loaderAcceptTypes, DecodeCharset: true}
+	tests := []struct {
+		path      string
+		mediaType string
+		body      string
+	}{
+		{"/pdf", "application/pdf", string(pdf)},
+		{"/binary", "application/octet-stream", string(binary)},
+		{"/sjis", "text/html", `<html><head><meta charset="shift_jis"></head><body>日本語`},
+	}
+	for _, tt := range tests {
+		resp, err := fetcher.Fetch(context.Background(), server.URL+tt.path, opts)
+		if err != nil {
+			t.Errorf("%s: %v", tt.path, err)
+			continue
+		}
+		if resp.MediaType != tt.mediaType || string(resp.Body) != tt.body {
+			t.Errorf("%s: media type = %q, body = %q, want %q, %q", tt.path, resp.MediaType, resp.Body, tt.mediaType, tt.body)
+		}
+	}
+}
@@ -2,28 +2,70 @@ package artisum
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
 type FileRepository struct {
+	dirPath         string
+	date            string
+	modelName       string
+	labels          *summaryLabels
 	summaryPath     string
 	feedPath        string
 	executeTimePath string
+	ledgerPath      string
+	runDirPath      string
+	feedStatePath   string
+	feedCacheDir    string
+	archiveMu       sync.Mutex
 }
 
-func NewFileRepository(dirPath, modelName string, now time.Time) *FileRepository {
+// SummaryArchive is the JSON archive of the summaries of a day.
+type SummaryArchive struct {
+	Date     string            `json:"date"`
+	Model    string            `json:"model"`
+	Articles []*SummaryArticle `json:"articles"`
+}
+
+// modelFileNameReplacer makes a model name, e.g. "meta-llama/Llama-3-8b" or "llama3:8b", a part of a file name.
+var modelFileNameReplacer = strings.NewReplacer("/", "_", "\\", "_", ":", "_")
+
+// NewFileRepository returns the repository of the files of the day. The Markdown digests are written in language.
+func NewFileRepository(dirPath, modelName, language string, now time.Time) *FileRepository {
 	nowStr := now.Format(time.DateOnly)
-	summaryPath := fmt.Sprintf("%s/%s_%s_summary", dirPath, modelName, nowStr)
-	feedPath := fmt.Sprintf("%s/%s_%s_feed.json", dirPath, modelName, nowStr)
+	fileModelName := modelFileNameReplacer.Replace(modelName)
+	summaryPath := fmt.Sprintf("%s/%s_%s_summary", dirPath, fileModelName, nowStr)
+	feedPath := fmt.Sprintf("%s/%s_%s_feed.json", dirPath, fileModelName, nowStr)
 	executeTimePath := fmt.Sprintf("%s/execute_time", dirPath)
+	ledgerPath := fmt.Sprintf("%s/ledger.json", dirPath)
+	runDirPath := fmt.Sprintf("%s/runs", dirPath)
+	feedStatePath := fmt.Sprintf("%s/feed_state.json", dirPath)
+	feedCacheDir := fmt.Sprintf("%s/feeds", dirPath)
 	return &FileRepository{
+		dirPath:         dirPath,
+		date:            nowStr,
+		modelName:       modelName,
+		labels:          labelsFor(language),
 		summaryPath:     summaryPath,
 		feedPath:        feedPath,
 		executeTimePath: executeTimePath,
+		ledgerPath:      ledgerPath,
+		runDirPath:      runDirPath,
+		feedStatePath:   feedStatePath,
+		feedCacheDir:    feedCacheDir,
 	}
 }
 
@@ -63,3 +105,236 @@ func (f *FileRepository) GetLatestExecuteTime() (time.Time, error) {
 
 	return t, nil
 }
+
+func (f *FileRepository) SaveLedger(ledger *Ledger) error {
+	entries := ledger.Entries()
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].URL < entries[j].URL
+	})
+
+	file, err := os.Create(f.ledgerPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(entries)
+}
+
+func (f *FileRepository) GetLedger() (*Ledger, error) {
+	file, err := os.Open(f.ledgerPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return NewLedger(nil), nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []*LedgerEntry
+	if err := json.NewDecoder(file).Decode(&entries); err != nil {
+		return nil, err
+	}
+
+	return NewLedger(entries), nil
+}
+
+func (f *FileRepository) SaveRun(run *Run) error {
+	if err := os.MkdirAll(f.runDirPath, 0755); err != nil {
+		return err
+	}
+
+	file, err := os.Create(f.runPath(run.ID))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(run)
+}
+
+func (f *FileRepository) GetRun(id string) (*Run, error) {
+	file, err := os.Open(f.runPath(id))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("run %s is not found", id)
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	var run *Run
+	if err := json.NewDecoder(file).Decode(&run); err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+func (f *FileRepository) runPath(id string) string {
+	return fmt.Sprintf("%s/%s.json", f.runDirPath, id)
+}
+
+func (f *FileRepository) SaveFeedStates(states map[string]*FeedState) error {
+	file, err := os.Create(f.feedStatePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(states)
+}
+
+func (f *FileRepository) GetFeedStates() (map[string]*FeedState, error) {
+	file, err := os.Open(f.feedStatePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return make(map[string]*FeedState), nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	var states map[string]*FeedState
+	if err := json.NewDecoder(file).Decode(&states); err != nil {
+		return nil, err
+	}
+	return states, nil
+}
+
+func (f *FileRepository) SaveFeedCache(feedUrl string, body []byte) error {
+	if err := os.MkdirAll(f.feedCacheDir, 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(f.feedCachePath(feedUrl), body, 0644)
+}
+
+func (f *FileRepository) GetFeedCache(feedUrl string) ([]byte, error) {
+	body, err := os.ReadFile(f.feedCachePath(feedUrl))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("feed %s is not cached", feedUrl)
+		}
+		return nil, err
+	}
+	return body, nil
+}
+
+func (f *FileRepository) feedCachePath(feedUrl string) string {
+	sum := sha256.Sum256([]byte(feedUrl))
+	return fmt.Sprintf("%s/%s", f.feedCacheDir, hex.EncodeToString(sum[:8]))
+}
+
+// SaveFeedSnapshot writes the articles fetched from the feeds, keyed by feed URL.
+func (f *FileRepository) SaveFeedSnapshot(articles map[string][]*Article) error {
+	file, err := os.Create(f.feedPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(articles)
+}
+
+// SaveSummaryResult adds the article to the archive of the day, a JSON file and a Markdown digest, and returns the path of the digest.
+// An article saved again, e.g. by a resumed run, replaces the previous one.
+func (f *FileRepository) SaveSummaryResult(_ context.Context, article *SummaryArticle) (string, error) {
+	f.archiveMu.Lock()
+	defer f.archiveMu.Unlock()
+
+	archive, err := readSummaryArchive(f.summaryPath + ".json")
+	if err != nil {
+		return "", err
+	}
+	if archive == nil {
+		archive = &SummaryArchive{Date: f.date, Model: f.modelName}
+	}
+	replaced := false
+	for i, a := range archive.Articles {
+		if a.Origin.URL == article.Origin.URL {
+			archive.Articles[i] = article
+			replaced = true
+		}
+	}
+	if !replaced {
+		archive.Articles = append(archive.Articles, article)
+	}
+
+	b, err := json.MarshalIndent(archive, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(f.summaryPath+".json", append(b, '\n'), 0644); err != nil {
+		return "", err
+	}
+	markdownPath := f.summaryPath + ".md"
+	return markdownPath, os.WriteFile(markdownPath, archive.markdown(f.labels), 0644)
+}
+
+// GetSummaryArchives reads the archives of every day and model, oldest first.
+func (f *FileRepository) GetSummaryArchives() ([]*SummaryArchive, error) {
+	paths, err := filepath.Glob(filepath.Join(f.dirPath, "*_summary.json"))
+	if err != nil {
+		return nil, err
+	}
+	archives := make([]*SummaryArchive, 0, len(paths))
+	for _, path := range paths {
+		archive, err := readSummaryArchive(path)
+		if err != nil {
+			return nil, err
+		}
+		if archive != nil {
+			archives = append(archives, archive)
+		}
+	}
+	sort.SliceStable(archives, func(i, j int) bool { return archives[i].Date < archives[j].Date })
+	return archives, nil
+}
+
+func readSummaryArchive(path string) (*SummaryArchive, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var archive *SummaryArchive
+	if err := json.Unmarshal(b, &archive); err != nil {
+		return nil, fmt.Errorf("invalid summary archive %s: %w", path, err)
+	}
+	return archive, nil
+}
+
+func (a *SummaryArchive) markdown(labels *summaryLabels) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# %s\n", labels.ArchiveTitle(a.Date))
+	for _, article := range a.Articles {
+		fmt.Fprintf(&b, "\n## [%s](%s)\n\n", article.Origin.Title, article.Origin.URL)
+		fmt.Fprintf(&b, "- %s: %s\n", labels.Tag, article.Origin.Tag)
+		if article.Origin.Reason != "" {
+			fmt.Fprintf(&b, "- %s: %d/%d %s\n", labels.Reason, article.Origin.Score, maxSelectionScore, article.Origin.Reason)
+		}
+		for _, name := range sortedKeys(article.Locations) {
+			fmt.Fprintf(&b, "- %s: %s\n", name, article.Locations[name])
+		}
+		for _, c := range article.Contents {
+			fmt.Fprintf(&b, "\n### %s\n\n", c.Heading)
+			if c.Type == SummaryFieldList {
+				for _, s := range c.Sentences {
+					fmt.Fprintf(&b, "- %s\n", s)
+				}
+				continue
+			}
+			fmt.Fprintf(&b, "%s\n", strings.Join(c.Sentences, "\n"))
+		}
+	}
+	return b.Bytes()
+}
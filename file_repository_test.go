@@ -0,0 +1,99 @@
+package artisum
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSummaryArchiveMarkdown(t *testing.T) {
+	archive := &SummaryArchive{
+		Date: "2024-08-13",
+		Articles: []*SummaryArticle{{
+			Origin:    &InterestArticle{Title: "Go 1.23", URL: "https://go.dev/blog/go1.23", Tag: "golang", Score: 4, Reason: "release"},
+			Contents:  []*FormatContent{{Heading: "Key points", Type: SummaryFieldList, Sentences: []string{"iterators"}}},
+			Locations: map[string]string{"notion": "https://notion.so/page"},
+		}},
+	}
+	want := "# Summaries for 2024-08-13\n\n## [Go 1.23](https://go.dev/blog/go1.23)\n\n- Tag: golang\n- Why it was selected: 4/10 release\n- notion: https://notion.so/page\n\n### Key points\n\n- iterators\n"
+	if got := string(archive.markdown(labelsFor("en"))); got != want {
+		t.Errorf("markdown = %q, want %q", got, want)
+	}
+	if got := string(archive.markdown(labelsFor("ja"))); !strings.HasPrefix(got, "# 2024-08-13 の要約\n") || !strings.Contains(got, "- タグ: golang\n- 選定理由: 4/10 release") {
+		t.Errorf("markdown = %q", got)
+	}
+}
+
+func TestFileRepositorySaveSummaryResult(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2024, 8, 13, 9, 0, 0, 0, time.UTC)
+	repo := NewFileRepository(dir, "meta-llama/Llama-3-8b", "en", now)
+	ctx := context.Background()
+
+	articles := testSummaryArticles(2)
+	for _, article := range articles {
+		if _, err := repo.SaveSummaryResult(ctx, article); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A resumed run saves the first article again.
+	resaved := *articles[0]
+	resaved.Contents = []*FormatContent{{Heading: "Summary", Type: SummaryFieldText, Sentences: []string{"Resumed."}}}
+	path, err := repo.SaveSummaryResult(ctx, &resaved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, "meta-llama_Llama-3-8b_2024-08-13_summary.md"); path != want {
+		t.Errorf("path = %q, want %q", path, want)
+	}
+
+	archives, err := repo.GetSummaryArchives()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archives) != 1 {
+		t.Fatalf("got %d archives, want 1", len(archives))
+	}
+	archive := archives[0]
+	if archive.Date != "2024-08-13" || archive.Model != "meta-llama/Llama-3-8b" || len(archive.Articles) != 2 {
+		t.Fatalf("archive = %+v", archive)
+	}
+	if got := archive.Articles[0]; got.Origin.URL != "https://example.com/0" || len(got.Contents) != 1 || got.Contents[0].Sentences[0] != "Resumed." {
+		t.Errorf("the article saved again is not replaced: %+v", got)
+	}
+	if got := archive.Articles[1]; got.Origin.URL != "https://example.com/1" || got.Locations["notion"] != "https://notion.so/1" {
+		t.Errorf("article = %+v", got)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(string(b), "## [Article 0]"); got != 1 {
+		t.Errorf("the digest has the article %d times:\n%s", got, b)
+	}
+}
+
+func TestFileRepositorySaveFeedSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	repo := NewFileRepository(dir, "hf.co/bartowski/Llama-3.2-1B-Instruct-GGUF:Q4_K_M", "ja", time.Date(2024, 8, 13, 0, 0, 0, 0, time.UTC))
+	articles := map[string][]*Article{"https://example.com/feed": {{Title: "Go 1.23", Url: "https://go.dev/blog/go1.23"}}}
+	if err := repo.SaveFeedSnapshot(articles); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "hf.co_bartowski_Llama-3.2-1B-Instruct-GGUF_Q4_K_M_2024-08-13_feed.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string][]*Article
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got["https://example.com/feed"]) != 1 || got["https://example.com/feed"][0].Title != "Go 1.23" {
+		t.Errorf("snapshot = %s", b)
+	}
+}
@@ -0,0 +1,258 @@
+package artisum
+
+import (
+	"fmt"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ArticleFilter is a set of rules an article has to pass to be a candidate for a tag. Empty rules are not applied.
+type ArticleFilter struct {
+	// IncludeKeywords requires one of the keywords in the title or content, case-insensitively.
+	IncludeKeywords []string `json:"includeKeywords,omitempty"`
+	ExcludeKeywords []string `json:"excludeKeywords,omitempty"`
+	// IncludePatterns and ExcludePatterns are regular expressions matched against the title and content.
+	IncludePatterns []string `json:"includePatterns,omitempty"`
+	ExcludePatterns []string `json:"excludePatterns,omitempty"`
+	// AllowDomains and DenyDomains match the host of the article URL and its subdomains.
+	AllowDomains []string `json:"allowDomains,omitempty"`
+	DenyDomains  []string `json:"denyDomains,omitempty"`
+	// MinLength is the minimum number of characters of the content.
+	MinLength int `json:"minLength,omitempty"`
+	// Languages are ISO 639-1 codes, e.g. "ja" or "en". The language of the feed is used when it is configured,
+	// otherwise it is detected from the text.
+	Languages   []string `json:"languages,omitempty"`
+	MaxAgeHours int      `json:"maxAgeHours,omitempty"`
+}
+
+type compiledFilter struct {
+	*ArticleFilter
+	tag             string
+	includePatterns []*regexp.Regexp
+	excludePatterns []*regexp.Regexp
+}
+
+// Prefilter drops the articles which pass the filter of no tag, before they are sent to the LLM,
+// and restricts the others to the tags whose filter they pass. A tag without a filter accepts every article.
+type Prefilter struct {
+	filters []*compiledFilter
+	feeds   map[string]*FeedConfig
+	// unfiltered are the names of the tags without a filter.
+	unfiltered []string
+}
+
+func NewPrefilter(tags []*InterestTag, feeds []*FeedConfig) (*Prefilter, error) {
+	p := &Prefilter{feeds: make(map[string]*FeedConfig, len(feeds))}
+	for _, f := range feeds {
+		p.feeds[f.URL] = f
+	}
+	for _, tag := range tags {
+		if tag.Filter == nil {
+			p.unfiltered = append(p.unfiltered, tag.Name)
+			continue
+		}
+		filter := &compiledFilter{ArticleFilter: tag.Filter, tag: tag.Name}
+		for _, pattern := range tag.Filter.IncludePatterns {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid include pattern of tag %s: %w", tag.Name, err)
+			}
+			filter.includePatterns = append(filter.includePatterns, re)
+		}
+		for _, pattern := range tag.Filter.ExcludePatterns {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid exclude pattern of tag %s: %w", tag.Name, err)
+			}
+			filter.excludePatterns = append(filter.excludePatterns, re)
+		}
+		p.filters = append(p.filters, filter)
+	}
+	return p, nil
+}
+
+// Filter returns the articles which pass the filter of any tag. An article rejected by the filter of some tag
+// is returned as a copy with AllowedTags set to the others. The age of articles is measured from now.
+func (p *Prefilter) Filter(articlesMap map[string][]*Article, now time.Time) map[string][]*Article {
+	if len(p.filters) == 0 {
+		return articlesMap
+	}
+
+	filtered := make(map[string][]*Article, len(articlesMap))
+	dropped := 0
+	for feedURL, articles := range articlesMap {
+		var feedLanguage string
+		if feed, ok := p.feeds[feedURL]; ok {
+			feedLanguage = feed.Language
+		}
+		for _, article := range articles {
+			text := filterText(article)
+			allowed := append([]string(nil), p.unfiltered...)
+			var reasons []string
+			for _, filter := range p.filters {
+				if reason := filter.check(article, text, feedLanguage, now); reason != "" {
+					reasons = append(reasons, filter.tag+": "+reason)
+					continue
+				}
+				allowed = append(allowed, filter.tag)
+			}
+			if len(allowed) == 0 {
+				dropped++
+				slog.Info("drop article", slog.String("url", article.Url), slog.String("title", article.Title), slog.String("reason", strings.Join(reasons, "; ")))
+				continue
+			}
+			if len(reasons) > 0 {
+				restricted := *article
+				restricted.AllowedTags = allowed
+				slog.Info("restrict tags of article", slog.String("url", article.Url), slog.String("tags", strings.Join(allowed, ", ")), slog.String("reason", strings.Join(reasons, "; ")))
+				article = &restricted
+			}
+			filtered[feedURL] = append(filtered[feedURL], article)
+		}
+	}
+	slog.Info("prefiltered articles", slog.Int("dropped", dropped))
+	return filtered
+}
+
+// check returns why the article does not pass the filter, or "" when it passes.
+func (f *compiledFilter) check(article *Article, text, feedLanguage string, now time.Time) string {
+	if host := articleHost(article.Url); host != "" {
+		if domain, ok := matchDomain(host, f.DenyDomains); ok {
+			return fmt.Sprintf("domain %s is denied", domain)
+		}
+		if len(f.AllowDomains) > 0 {
+			if _, ok := matchDomain(host, f.AllowDomains); !ok {
+				return fmt.Sprintf("domain %s is not allowed", host)
+			}
+		}
+	}
+	if f.MaxAgeHours > 0 && !article.Datetime.IsZero() {
+		if age := now.Sub(article.Datetime); age > time.Duration(f.MaxAgeHours)*time.Hour {
+			return fmt.Sprintf("older than %d hours", f.MaxAgeHours)
+		}
+	}
+	if f.MinLength > 0 {
+		if n := utf8.RuneCountInString(filterContent(article)); n < f.MinLength {
+			return fmt.Sprintf("content has %d characters, less than %d", n, f.MinLength)
+		}
+	}
+	if len(f.Languages) > 0 {
+		language := feedLanguage
+		if language == "" {
+			language = detectLanguage(text)
+		}
+		if !containsFold(f.Languages, language) {
+			return fmt.Sprintf("language %q is not one of %s", language, strings.Join(f.Languages, ", "))
+		}
+	}
+
+	lower := strings.ToLower(text)
+	for _, keyword := range f.ExcludeKeywords {
+		if strings.Contains(lower, strings.ToLower(keyword)) {
+			return fmt.Sprintf("excluded keyword %q", keyword)
+		}
+	}
+	for _, re := range f.excludePatterns {
+		if re.MatchString(text) {
+			return fmt.Sprintf("excluded pattern %q", re.String())
+		}
+	}
+	if len(f.IncludeKeywords) > 0 || len(f.includePatterns) > 0 {
+		included := false
+		for _, keyword := range f.IncludeKeywords {
+			if strings.Contains(lower, strings.ToLower(keyword)) {
+				included = true
+				break
+			}
+		}
+		for _, re := range f.includePatterns {
+			if included {
+				break
+			}
+			included = re.MatchString(text)
+		}
+		if !included {
+			return "no include keyword or pattern"
+		}
+	}
+	return ""
+}
+
+func filterText(article *Article) string {
+	return article.Title + "\n" + filterContent(article)
+}
+
+// filterContent is the content of the article without markup, falling back to the description.
+func filterContent(article *Article) string {
+	content := article.Content
+	if strings.TrimSpace(content) == "" {
+		content = article.Description
+	}
+	return collapseSpaces(htmlTagRegexp.ReplaceAllString(content, " "))
+}
+
+func articleHost(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+}
+
+func matchDomain(host string, domains []string) (string, bool) {
+	for _, d := range domains {
+		d = strings.TrimPrefix(strings.ToLower(d), "www.")
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return d, true
+		}
+	}
+	return "", false
+}
+
+func containsFold(values []string, s string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// detectLanguage guesses the language from the scripts in the text: kana for Japanese, Hangul for Korean,
+// Han without kana for Chinese and mostly Latin letters for English. It returns "" when it can not tell.
+func detectLanguage(text string) string {
+	var kana, hangul, han, latin, letters int
+	for _, r := range text {
+		switch {
+		case unicode.In(r, unicode.Hiragana, unicode.Katakana):
+			kana++
+		case unicode.Is(unicode.Hangul, r):
+			hangul++
+		case unicode.Is(unicode.Han, r):
+			han++
+		case unicode.Is(unicode.Latin, r):
+			latin++
+		default:
+			continue
+		}
+		letters++
+	}
+	switch {
+	case letters == 0:
+		return ""
+	case kana > 0 && kana*20 >= letters:
+		return "ja"
+	case hangul*5 >= letters:
+		return "ko"
+	case han*5 >= letters:
+		return "zh"
+	case latin*2 >= letters:
+		return "en"
+	}
+	return ""
+}
@@ -0,0 +1,238 @@
+package artisum
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrefilterAllowedTags(t *testing.T) {
+	now := time.Date(2024, 8, 13, 0, 0, 0, 0, time.UTC)
+	go122 := &Article{Title: "Go 1.22 release notes", Url: "https://go.dev/blog/go1.22", Content: "golang generics", Datetime: now}
+	tokio := &Article{Title: "Tokio internals", Url: "https://tokio.rs/blog", Content: "rust async runtime", Datetime: now}
+	spam := &Article{Title: "Buy now", Url: "https://spam.example.com/", Content: "sale", Datetime: now}
+	articles := map[string][]*Article{"https://feed.example.com": {go122, tokio, spam}}
+
+	tags := []*InterestTag{
+		{Name: "golang", Filter: &ArticleFilter{IncludeKeywords: []string{"golang"}}},
+		{Name: "rust", Filter: &ArticleFilter{IncludeKeywords: []string{"rust"}}},
+	}
+	p, err := NewPrefilter(tags, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := p.Filter(articles, now)["https://feed.example.com"]
+	if len(got) != 2 {
+		t.Fatalf("got %d articles, want 2", len(got))
+	}
+	if !slices.Equal(got[0].AllowedTags, []string{"golang"}) || !slices.Equal(got[1].AllowedTags, []string{"rust"}) {
+		t.Errorf("allowed tags = %q, %q", got[0].AllowedTags, got[1].AllowedTags)
+	}
+	if go122.AllowedTags != nil {
+		t.Error("the input article is changed")
+	}
+
+	// A tag without a filter accepts every article, so nothing is dropped, but the filtered tag is still enforced.
+	p, err = NewPrefilter(append(tags, &InterestTag{Name: "misc"}), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = p.Filter(articles, now)["https://feed.example.com"]
+	if len(got) != 3 {
+		t.Fatalf("got %d articles, want 3", len(got))
+	}
+	if !slices.Equal(got[0].AllowedTags, []string{"misc", "golang"}) || !slices.Equal(got[2].AllowedTags, []string{"misc"}) {
+		t.Errorf("allowed tags = %q, %q", got[0].AllowedTags, got[2].AllowedTags)
+	}
+
+	// An article passing every filter can have any tag.
+	p, err = NewPrefilter([]*InterestTag{{Name: "golang", Filter: &ArticleFilter{MaxAgeHours: 24}}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := p.Filter(articles, now)["https://feed.example.com"]; len(got) != 3 || got[0] != go122 {
+		t.Errorf("got %v", got)
+	}
+}
+
+func TestCompiledFilterCheck(t *testing.T) {
+	now := time.Date(2024, 8, 13, 12, 0, 0, 0, time.UTC)
+	article := func(rawURL, content string) *Article {
+		return &Article{Title: "Go 1.23 release notes", Url: rawURL, Content: content, Datetime: now.Add(-time.Hour)}
+	}
+	tests := []struct {
+		name         string
+		filter       *ArticleFilter
+		article      *Article
+		feedLanguage string
+		// want is a part of the reason, or "" when the article passes.
+		want string
+	}{
+		{
+			name:    "denied domain",
+			filter:  &ArticleFilter{DenyDomains: []string{"Example.com"}},
+			article: article("https://www.example.com/post", "golang"),
+			want:    "domain example.com is denied",
+		},
+		{
+			name:    "denied parent domain",
+			filter:  &ArticleFilter{DenyDomains: []string{"example.com"}},
+			article: article("https://blog.example.com/post", "golang"),
+			want:    "domain example.com is denied",
+		},
+		{
+			name:    "domain with the denied suffix",
+			filter:  &ArticleFilter{DenyDomains: []string{"example.com"}},
+			article: article("https://notexample.com/post", "golang"),
+		},
+		{
+			name:    "allowed subdomain",
+			filter:  &ArticleFilter{AllowDomains: []string{"go.dev"}},
+			article: article("https://tip.go.dev/doc", "golang"),
+		},
+		{
+			name:    "not allowed domain",
+			filter:  &ArticleFilter{AllowDomains: []string{"go.dev"}},
+			article: article("https://example.com/go", "golang"),
+			want:    "domain example.com is not allowed",
+		},
+		{
+			name:    "denied before allowed",
+			filter:  &ArticleFilter{AllowDomains: []string{"go.dev"}, DenyDomains: []string{"tip.go.dev"}},
+			article: article("https://tip.go.dev/doc", "golang"),
+			want:    "domain tip.go.dev is denied",
+		},
+		{
+			name:    "include pattern in the title",
+			filter:  &ArticleFilter{IncludePatterns: []string{`Go 1\.\d+`}},
+			article: article("https://go.dev/blog", "release"),
+		},
+		{
+			name:    "no include pattern",
+			filter:  &ArticleFilter{IncludePatterns: []string{`(?i)\brust\b`}},
+			article: article("https://go.dev/blog", "trusted release"),
+			want:    "no include keyword or pattern",
+		},
+		{
+			name:    "include keyword without the pattern",
+			filter:  &ArticleFilter{IncludeKeywords: []string{"GOLANG"}, IncludePatterns: []string{`rust`}},
+			article: article("https://go.dev/blog", "golang"),
+		},
+		{
+			name:    "excluded pattern",
+			filter:  &ArticleFilter{IncludeKeywords: []string{"golang"}, ExcludePatterns: []string{`(?i)sponsored`}},
+			article: article("https://go.dev/blog", "golang, Sponsored"),
+			want:    `excluded pattern "(?i)sponsored"`,
+		},
+		{
+			name:    "short content",
+			filter:  &ArticleFilter{MinLength: 10},
+			article: article("https://go.dev/blog", "<p>short</p>"),
+			want:    "content has 5 characters, less than 10",
+		},
+		{
+			name:    "long description",
+			filter:  &ArticleFilter{MinLength: 10},
+			article: &Article{Url: "https://go.dev/blog", Content: " \n", Description: "<p>長い説明の文章です。</p>", Datetime: now},
+		},
+		{
+			name:    "short description",
+			filter:  &ArticleFilter{MinLength: 10},
+			article: &Article{Url: "https://go.dev/blog", Description: "<b>短い説明</b>", Datetime: now},
+			want:    "content has 4 characters, less than 10",
+		},
+		{
+			name:         "language of the feed",
+			filter:       &ArticleFilter{Languages: []string{"JA"}},
+			article:      article("https://go.dev/blog", "golang"),
+			feedLanguage: "ja",
+		},
+		{
+			name:         "language of the feed over the text",
+			filter:       &ArticleFilter{Languages: []string{"en"}},
+			article:      article("https://go.dev/blog", "golang"),
+			feedLanguage: "ja",
+			want:         `language "ja" is not one of en`,
+		},
+		{
+			name:    "detected language",
+			filter:  &ArticleFilter{Languages: []string{"ja"}},
+			article: article("https://go.dev/blog", "Go 1.23 ではイテレータが導入されました"),
+		},
+		{
+			name:    "other detected language",
+			filter:  &ArticleFilter{Languages: []string{"ja"}},
+			article: article("https://go.dev/blog", "range over func is released"),
+			want:    `language "en" is not one of ja`,
+		},
+		{
+			name:    "old article",
+			filter:  &ArticleFilter{MaxAgeHours: 24},
+			article: &Article{Url: "https://go.dev/blog", Datetime: now.Add(-25 * time.Hour)},
+			want:    "older than 24 hours",
+		},
+		{
+			name:    "recent article",
+			filter:  &ArticleFilter{MaxAgeHours: 24},
+			article: &Article{Url: "https://go.dev/blog", Datetime: now.Add(-23 * time.Hour)},
+		},
+		{
+			name:    "undated article",
+			filter:  &ArticleFilter{MaxAgeHours: 24},
+			article: &Article{Url: "https://go.dev/blog"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewPrefilter([]*InterestTag{{Name: "golang", Filter: tt.filter}}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := p.filters[0].check(tt.article, filterText(tt.article), tt.feedLanguage, now)
+			if (tt.want == "") != (got == "") || !strings.Contains(got, tt.want) {
+				t.Errorf("reason = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrefilterFeedLanguage(t *testing.T) {
+	now := time.Date(2024, 8, 13, 0, 0, 0, 0, time.UTC)
+	article := &Article{Title: "Go 1.23", Url: "https://go.dev/blog", Content: "range over func", Datetime: now}
+	articles := map[string][]*Article{"https://ja.example.com/feed": {article}, "https://en.example.com/feed": {article}}
+	feeds := []*FeedConfig{{URL: "https://ja.example.com/feed", Language: "ja"}}
+	p, err := NewPrefilter([]*InterestTag{{Name: "golang", Filter: &ArticleFilter{Languages: []string{"ja"}}}}, feeds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := p.Filter(articles, now)
+	if len(got["https://ja.example.com/feed"]) != 1 || len(got["https://en.example.com/feed"]) != 0 {
+		t.Errorf("got %v, want only the article of the Japanese feed", got)
+	}
+}
+
+func TestNewPrefilterInvalidPattern(t *testing.T) {
+	_, err := NewPrefilter([]*InterestTag{{Name: "golang", Filter: &ArticleFilter{ExcludePatterns: []string{"("}}}}, nil)
+	if err == nil || !strings.Contains(err.Error(), "invalid exclude pattern of tag golang") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestDetectLanguage(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"Go 1.23 ではイテレータが導入されました", "ja"},
+		{"Go 1.23 引入了迭代器", "zh"},
+		{"Go 1.23 에서 이터레이터가 도입되었습니다", "ko"},
+		{"Go 1.23 introduces iterators", "en"},
+		{"1.23 / 2024", ""},
+	}
+	for _, tt := range tests {
+		if got := detectLanguage(tt.text); got != tt.want {
+			t.Errorf("detectLanguage(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
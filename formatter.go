@@ -3,116 +3,144 @@ package artisum
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
 
 	"github.com/tmc/langchaingo/llms"
-	"github.com/tmc/langchaingo/llms/openai"
 	"github.com/tmc/langchaingo/prompts"
+	"github.com/tmc/langchaingo/textsplitter"
+	"golang.org/x/sync/errgroup"
 )
 
+const formatChunkConcurrency = 4
+
 type ArticleFormatter struct {
-	formatLLM            *openai.LLM
-	gpt35Turbo           *openai.LLM
+	formatLLM  llms.Model
+	structured *StructuredOutput
+	fetcher    *Fetcher
+	// countTokens counts the tokens of a text for the format model.
+	countTokens          func(string) int
+	tokenBudget          int
+	summary              *SummarySchema
+	formatSchema         *OutputSchema
 	formatPromptTemplate prompts.PromptTemplate
-	toJsonPromptTemplate prompts.PromptTemplate
+	chunkPromptTemplate  prompts.PromptTemplate
 }
 
+// FormatContent is a field of the summary. Sentences is the text to display for every type;
+// Option and Rating keep the typed value of enum and rating fields.
+// Contents saved before the summary schema existed only have Heading and Sentences.
 type FormatContent struct {
-	Heading   string   `json:"heading"`
-	Sentences []string `json:"sentences"`
+	Heading   string           `json:"heading"`
+	Sentences []string         `json:"sentences"`
+	Field     string           `json:"field,omitempty"`
+	Type      SummaryFieldType `json:"type,omitempty"`
+	Option    string           `json:"option,omitempty"`
+	Rating    *float64         `json:"rating,omitempty"`
 }
 
-func NewArticleFormatter(modelName string) (*ArticleFormatter, error) {
-	formatLLM, err := openai.New(
-		openai.WithModel(modelName),
-		openai.WithCallback(NewArtisumLogHandler("記事のフォーマット")),
-	)
-	if err != nil {
-		return nil, err
-	}
-	gpt35Turbo, err := openai.New(openai.WithModel("gpt-3.5-turbo"))
-	if err != nil {
-		return nil, err
-	}
-
-	formatPromptBase :=
-		`## Introduction
-As an experienced IT engineer in web and game service development,
-you will access the Content listed under "## Input Content".
-Based on the content, please summarize and respond according to the items listed below.
-
-1. Summary of the content
-2. Key points in the content
-3. Background and issues discussed in the article
-4. Approaches taken regarding the background and issues
-5. Results of the approaches
-6. Technical keywords(please split the term with a comma if there are multiple terms)
-
-And Please output the summary result in the following json format.
-[
-	{
-		"heading": "Title", // please use above item
-		"sentences": [ // please provide answers corresponding to the items listed above.
-			"sentence1",
-			"sentence2",
-		]
-	}
-]
-
-Ensure that results of 'senetences' are always returned in an array format, even if there is only one value or no value.
-When converting to json format, please translate to Japanese, excluding the 6th item's "sentences".
-
-
-## Input Content
-{{.context}}`
-
-	formatPromptTemplate := prompts.NewPromptTemplate(formatPromptBase, []string{"context"})
-
-	toJsonPromptBase := `
-	Please convert the value of "formatted_content" into JSON format.
-	Ensure that the JSON format is correct and not missing any data.
-
-	formatted_content
-	{{.context}}
-	`
-	toJsonPromptTemplate := prompts.NewPromptTemplate(toJsonPromptBase, []string{"context"})
-
+func NewArticleFormatter(formatLLM llms.Model, conf *LLMConfig, repairer *JSONRepairer, promptSet *PromptSet, summary *SummarySchema, fetcher *Fetcher) (*ArticleFormatter, error) {
 	return &ArticleFormatter{
 		formatLLM:            formatLLM,
-		gpt35Turbo:           gpt35Turbo,
-		formatPromptTemplate: formatPromptTemplate,
-		toJsonPromptTemplate: toJsonPromptTemplate,
+		structured:           NewStructuredOutput(formatLLM, conf, repairer),
+		fetcher:              fetcher,
+		countTokens:          func(text string) int { return countTokens(conf.Model, text) },
+		tokenBudget:          conf.tokenBudget(),
+		summary:              summary,
+		formatSchema:         summary.outputSchema(),
+		formatPromptTemplate: promptSet.Template(PromptFormat),
+		chunkPromptTemplate:  promptSet.Template(PromptChunk),
 	}, nil
 }
 
 func (f *ArticleFormatter) Format(ctx context.Context, url string) ([]*FormatContent, error) {
-	textContent, err := ExtractTextContentFromURL(url)
+	pageContent, err := ExtractContentFromURL(ctx, f.fetcher, url)
 	if err != nil {
 		return nil, err
 	}
-	formatPrompt, err := f.formatPromptTemplate.Format(map[string]any{"context": textContent})
+	content, err := f.fitContent(ctx, pageContent.String())
 	if err != nil {
 		return nil, err
 	}
-	resp, err := f.formatLLM.Call(ctx, formatPrompt)
+	formatPrompt, err := f.formatPromptTemplate.Format(map[string]any{"context": content})
 	if err != nil {
 		return nil, err
 	}
-
-	toJsonPrompt, err := f.toJsonPromptTemplate.Format(map[string]any{"context": resp})
-	if err != nil {
+	var result map[string]json.RawMessage
+	if err := f.structured.Generate(ctx, formatPrompt, f.formatSchema, &result); err != nil {
 		return nil, err
 	}
-	jsonResult, err := f.gpt35Turbo.Call(ctx, toJsonPrompt, llms.WithJSONMode())
+
+	return f.summary.contents(result)
+}
+
+// fitContent returns content as is when the format prompt fits in the token budget.
+// Otherwise the content is replaced by notes taken from each chunk of it, repeatedly until the notes fit.
+func (f *ArticleFormatter) fitContent(ctx context.Context, content string) (string, error) {
+	for {
+		prompt, err := f.formatPromptTemplate.Format(map[string]any{"context": content})
+		if err != nil {
+			return "", err
+		}
+		contentTokens := f.countTokens(content)
+		if f.countTokens(prompt) <= f.tokenBudget {
+			return content, nil
+		}
+
+		notes, err := f.summarizeChunks(ctx, content)
+		if err != nil {
+			return "", err
+		}
+		if f.countTokens(notes) >= contentTokens {
+			return "", fmt.Errorf("content can not be reduced into the token budget of %d", f.tokenBudget)
+		}
+		content = notes
+	}
+}
+
+func (f *ArticleFormatter) summarizeChunks(ctx context.Context, content string) (string, error) {
+	emptyPrompt, err := f.chunkPromptTemplate.Format(map[string]any{"index": 0, "total": 0, "context": ""})
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-	var formatArticle struct {
-		Result []*FormatContent `json:"formatted_content"`
+	chunkSize := f.tokenBudget - f.countTokens(emptyPrompt)
+	if chunkSize <= 0 {
+		return "", fmt.Errorf("token budget %d is too small for the prompt", f.tokenBudget)
 	}
-	err = json.Unmarshal([]byte(jsonResult), &formatArticle)
+
+	splitter := textsplitter.NewRecursiveCharacter(
+		textsplitter.WithChunkSize(chunkSize),
+		textsplitter.WithChunkOverlap(chunkSize/20),
+		textsplitter.WithSeparators([]string{"\n# ", "\n\n", "\n", "。", ". ", " "}),
+		textsplitter.WithLenFunc(f.countTokens),
+	)
+	chunks, err := splitter.SplitText(content)
 	if err != nil {
-		return nil, err
+		return "", err
+	}
+	slog.Info("summarize long content in chunks", slog.Int("chunks", len(chunks)), slog.Int("tokenBudget", f.tokenBudget))
+
+	notes := make([]string, len(chunks))
+	eg, ctx := errgroup.WithContext(ctx)
+	eg.SetLimit(formatChunkConcurrency)
+	for i, chunk := range chunks {
+		eg.Go(func() error {
+			prompt, err := f.chunkPromptTemplate.Format(map[string]any{"index": i + 1, "total": len(chunks), "context": chunk})
+			if err != nil {
+				return err
+			}
+			resp, err := f.formatLLM.Call(ctx, prompt)
+			if err != nil {
+				return err
+			}
+			notes[i] = fmt.Sprintf("# Notes on part %d of %d\n%s", i+1, len(chunks), strings.TrimSpace(resp))
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return "", err
 	}
 
-	return formatArticle.Result, nil
+	return "The following are notes taken from each part of a long article.\n\n" + strings.Join(notes, "\n\n"), nil
 }
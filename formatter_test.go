@@ -0,0 +1,119 @@
+package artisum
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/tmc/langchaingo/llms"
+	"github.com/tmc/langchaingo/prompts"
+)
+
+// llmFunc answers every prompt with the function. It is safe for concurrent calls when the function is.
+type llmFunc func(prompt string) string
+
+func (f llmFunc) GenerateContent(_ context.Context, messages []llms.MessageContent, _ ...llms.CallOption) (*llms.ContentResponse, error) {
+	return &llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: f(messageText(messages[len(messages)-1]))}}}, nil
+}
+
+func (f llmFunc) Call(ctx context.Context, prompt string, options ...llms.CallOption) (string, error) {
+	return llms.GenerateFromSinglePrompt(ctx, f, prompt, options...)
+}
+
+// newTestFormatter counts a word as a token, so that the tests never load a tiktoken encoding.
+func newTestFormatter(llm llms.Model, tokenBudget int) *ArticleFormatter {
+	template := func(source string, inputs ...string) prompts.PromptTemplate {
+		return prompts.PromptTemplate{Template: source, InputVariables: inputs, TemplateFormat: prompts.TemplateFormatGoTemplate}
+	}
+	return &ArticleFormatter{
+		formatLLM:            llm,
+		countTokens:          func(text string) int { return len(strings.Fields(text)) },
+		tokenBudget:          tokenBudget,
+		formatPromptTemplate: template("Summarize the article:\n{{.context}}", "context"),
+		chunkPromptTemplate:  template("Part {{.index}} of {{.total}}:\n{{.context}}", "index", "total", "context"),
+	}
+}
+
+// testLongContent has paragraphs of 10 words.
+func testLongContent(words int) string {
+	var paragraphs []string
+	for i := 0; i < words; i += 10 {
+		var paragraph []string
+		for j := i; j < min(i+10, words); j++ {
+			paragraph = append(paragraph, fmt.Sprintf("word%d", j))
+		}
+		paragraphs = append(paragraphs, strings.Join(paragraph, " "))
+	}
+	return strings.Join(paragraphs, "\n\n")
+}
+
+func TestArticleFormatterFitContentWithinBudget(t *testing.T) {
+	llm := llmFunc(func(string) string {
+		t.Error("the LLM is called for content within the budget")
+		return ""
+	})
+	f := newTestFormatter(llm, 20)
+	content := testLongContent(17)
+	got, err := f.fitContent(context.Background(), content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != content {
+		t.Errorf("got %q, want the content as is", got)
+	}
+}
+
+func TestArticleFormatterFitContentInChunks(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		calls []string
+	)
+	llm := llmFunc(func(prompt string) string {
+		mu.Lock()
+		defer mu.Unlock()
+		calls = append(calls, prompt)
+		return "a short note"
+	})
+	f := newTestFormatter(llm, 60)
+	got, err := f.fitContent(context.Background(), testLongContent(200))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(calls) < 4 {
+		t.Fatalf("got %d chunks of 200 words for the budget of 60", len(calls))
+	}
+	var chunked strings.Builder
+	for _, prompt := range calls {
+		if n := f.countTokens(prompt); n > 60 {
+			t.Errorf("a chunk prompt has %d tokens: %q", n, prompt)
+		}
+		chunked.WriteString(prompt)
+	}
+	for _, word := range []string{"word0", "word99", "word199"} {
+		if !strings.Contains(chunked.String(), word) {
+			t.Errorf("%s is not in any chunk", word)
+		}
+	}
+
+	if !strings.HasPrefix(got, "The following are notes taken from each part of a long article.") || strings.Contains(got, "word") {
+		t.Errorf("got %q, want the notes", got)
+	}
+	for i := 1; i <= len(calls); i++ {
+		if want := fmt.Sprintf("# Notes on part %d of %d\na short note", i, len(calls)); !strings.Contains(got, want) {
+			t.Errorf("got %q, want %q in it", got, want)
+		}
+	}
+}
+
+func TestArticleFormatterFitContentNotReduced(t *testing.T) {
+	// The notes repeat each part, so they never get shorter than the content.
+	llm := llmFunc(func(prompt string) string { return prompt })
+	f := newTestFormatter(llm, 60)
+	_, err := f.fitContent(context.Background(), testLongContent(200))
+	if err == nil || !strings.Contains(err.Error(), "can not be reduced into the token budget of 60") {
+		t.Errorf("err = %v", err)
+	}
+}
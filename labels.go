@@ -0,0 +1,128 @@
+package artisum
+
+import "fmt"
+
+// summaryLabels are the words around the summaries in the digests, archives and the site,
+// in the language the summaries are written in.
+type summaryLabels struct {
+	// Lang is the value of the lang attribute of HTML.
+	Lang     string
+	Tag      string
+	Reason   string
+	Keywords string
+	Search   string
+
+	digestTitle  string
+	archiveTitle string
+	articleCount string
+	readOn       string
+	// headings are the headings of defaultSummaryFields by name.
+	headings map[string]string
+}
+
+var languageLabels = map[string]*summaryLabels{
+	"ja": {
+		Lang:         "ja",
+		Tag:          "タグ",
+		Reason:       "選定理由",
+		Keywords:     "キーワード",
+		Search:       "記事を検索",
+		digestTitle:  "%s の記事要約",
+		archiveTitle: "%s の要約",
+		articleCount: "%d件",
+		readOn:       "%sで読む",
+		headings: map[string]string{
+			"summary":    "概要",
+			"key_points": "要点",
+			"background": "背景と課題",
+			"approaches": "アプローチ",
+			"results":    "結果",
+			"keywords":   "技術キーワード",
+		},
+	},
+	"en": {
+		Lang:         "en",
+		Tag:          "Tag",
+		Reason:       "Why it was selected",
+		Keywords:     "Keywords",
+		Search:       "Search articles",
+		digestTitle:  "Article summaries for %s",
+		archiveTitle: "Summaries for %s",
+		articleCount: "%d articles",
+		readOn:       "Read on %s",
+		headings: map[string]string{
+			"summary":    "Summary",
+			"key_points": "Key points",
+			"background": "Background and issues",
+			"approaches": "Approaches",
+			"results":    "Results",
+			"keywords":   "Technical keywords",
+		},
+	},
+	"zh": {
+		Lang:         "zh",
+		Tag:          "标签",
+		Reason:       "选择理由",
+		Keywords:     "关键词",
+		Search:       "搜索文章",
+		digestTitle:  "%s 的文章摘要",
+		archiveTitle: "%s 的摘要",
+		articleCount: "%d篇",
+		readOn:       "在%s阅读",
+		headings: map[string]string{
+			"summary":    "概要",
+			"key_points": "要点",
+			"background": "背景与问题",
+			"approaches": "方法",
+			"results":    "结果",
+			"keywords":   "技术关键词",
+		},
+	},
+	"ko": {
+		Lang:         "ko",
+		Tag:          "태그",
+		Reason:       "선정 이유",
+		Keywords:     "키워드",
+		Search:       "기사 검색",
+		digestTitle:  "%s 기사 요약",
+		archiveTitle: "%s 요약",
+		articleCount: "%d건",
+		readOn:       "%s에서 읽기",
+		headings: map[string]string{
+			"summary":    "개요",
+			"key_points": "요점",
+			"background": "배경과 과제",
+			"approaches": "접근 방식",
+			"results":    "결과",
+			"keywords":   "기술 키워드",
+		},
+	},
+}
+
+// labelsFor returns the labels of the prompt language. Languages without labels get English ones,
+// and an empty language, e.g. of a config loaded without defaults, the ones of the default language.
+func labelsFor(language string) *summaryLabels {
+	if language == "" {
+		language = defaultPromptLanguage
+	}
+	if labels, ok := languageLabels[language]; ok {
+		return labels
+	}
+	return languageLabels["en"]
+}
+
+func (l *summaryLabels) DigestTitle(date string) string {
+	return fmt.Sprintf(l.digestTitle, date)
+}
+
+func (l *summaryLabels) ArchiveTitle(date string) string {
+	return fmt.Sprintf(l.archiveTitle, date)
+}
+
+func (l *summaryLabels) ArticleCount(n int) string {
+	return fmt.Sprintf(l.articleCount, n)
+}
+
+func (l *summaryLabels) ReadOn(name string) string {
+	return fmt.Sprintf(l.readOn, name)
+}
@@ -0,0 +1,182 @@
+package artisum
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+type LedgerEntry struct {
+	URL          string     `json:"url"`
+	GUID         string     `json:"guid,omitempty"`
+	ContentHash  string     `json:"contentHash,omitempty"`
+	FirstSeenAt  time.Time  `json:"firstSeenAt"`
+	SummarizedAt *time.Time `json:"summarizedAt,omitempty"`
+}
+
+// Ledger keeps every article artisum has seen, keyed by canonical URL,
+// so that the same article is not summarized twice across runs.
+type Ledger struct {
+	mu      sync.Mutex
+	entries map[string]*LedgerEntry
+	guids   map[string]string
+	hashes  map[string]string
+}
+
+func NewLedger(entries []*LedgerEntry) *Ledger {
+	l := &Ledger{
+		entries: make(map[string]*LedgerEntry, len(entries)),
+		guids:   make(map[string]string),
+		hashes:  make(map[string]string),
+	}
+	for _, entry := range entries {
+		l.index(entry)
+	}
+	return l
+}
+
+func (l *Ledger) Entries() []*LedgerEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entries := make([]*LedgerEntry, 0, len(l.entries))
+	for _, entry := range l.entries {
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// Seen records the article as seen and returns its entry. The first-seen time is kept from the first call.
+func (l *Ledger) Seen(article *Article, now time.Time) *LedgerEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if entry := l.lookup(article); entry != nil {
+		if entry.GUID == "" && article.GUID != "" {
+			entry.GUID = article.GUID
+			l.guids[article.GUID] = entry.URL
+		}
+		return entry
+	}
+
+	entry := &LedgerEntry{
+		URL:         CanonicalizeURL(article.Url),
+		GUID:        article.GUID,
+		ContentHash: contentHash(article),
+		FirstSeenAt: now,
+	}
+	l.index(entry)
+	return entry
+}
+
+// IsSummarized reports whether the article, or the same article published under another URL or GUID, was already summarized.
+func (l *Ledger) IsSummarized(article *Article) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry := l.lookup(article)
+	return entry != nil && entry.SummarizedAt != nil
+}
+
+func (l *Ledger) FirstSeenAt(article *Article) (time.Time, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry := l.lookup(article)
+	if entry == nil {
+		return time.Time{}, false
+	}
+	return entry.FirstSeenAt, true
+}
+
+func (l *Ledger) MarkSummarized(rawURL string, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	canonical := CanonicalizeURL(rawURL)
+	entry, ok := l.entries[canonical]
+	if !ok {
+		entry = &LedgerEntry{URL: canonical, FirstSeenAt: now}
+		l.index(entry)
+	}
+	entry.SummarizedAt = &now
+}
+
+func (l *Ledger) lookup(article *Article) *LedgerEntry {
+	if entry, ok := l.entries[CanonicalizeURL(article.Url)]; ok {
+		return entry
+	}
+	if article.GUID != "" {
+		if u, ok := l.guids[article.GUID]; ok {
+			return l.entries[u]
+		}
+	}
+	if hash := contentHash(article); hash != "" {
+		if u, ok := l.hashes[hash]; ok {
+			return l.entries[u]
+		}
+	}
+	return nil
+}
+
+func (l *Ledger) index(entry *LedgerEntry) {
+	l.entries[entry.URL] = entry
+	if entry.GUID != "" {
+		l.guids[entry.GUID] = entry.URL
+	}
+	if entry.ContentHash != "" {
+		l.hashes[entry.ContentHash] = entry.URL
+	}
+}
+
+func contentHash(article *Article) string {
+	content := article.Content
+	if content == "" {
+		content = article.Description
+	}
+	content = strings.Join(strings.Fields(content), " ")
+	if content == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(strings.TrimSpace(article.Title) + "\n" + content))
+	return hex.EncodeToString(sum[:])
+}
+
+var trackingQueryPrefixes = []string{"utm_", "fbclid", "gclid", "mc_cid", "mc_eid", "ref_src"}
+
+// CanonicalizeURL normalizes rawURL so that the same article is identified regardless of
+// scheme/host casing, fragments, tracking parameters, query order or a trailing slash.
+func CanonicalizeURL(rawURL string) string {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil || u.Host == "" {
+		return strings.TrimSpace(rawURL)
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	if u.Scheme == "http" {
+		u.Scheme = "https"
+	}
+	u.Host = strings.TrimPrefix(strings.ToLower(u.Host), "www.")
+	u.Host = strings.TrimSuffix(u.Host, ":443")
+	u.Fragment = ""
+	u.RawFragment = ""
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	u.RawPath = ""
+
+	query := u.Query()
+	for key := range query {
+		for _, prefix := range trackingQueryPrefixes {
+			if strings.HasPrefix(strings.ToLower(key), prefix) {
+				query.Del(key)
+				break
+			}
+		}
+	}
+	// Encode sorts the keys.
+	u.RawQuery = query.Encode()
+
+	return u.String()
+}
@@ -0,0 +1,62 @@
+package artisum
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCanonicalizeURL(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"https://example.com/posts/1", "https://example.com/posts/1"},
+		{"HTTP://WWW.Example.com/posts/1/", "https://example.com/posts/1"},
+		{"https://example.com:443/posts/1#comments", "https://example.com/posts/1"},
+		{"https://example.com/posts/1?utm_source=rss&utm_medium=feed", "https://example.com/posts/1"},
+		{"https://example.com/search?q=go&a=1&fbclid=x", "https://example.com/search?a=1&q=go"},
+		{"https://example.com:8080/", "https://example.com:8080"},
+		{"  not a url  ", "not a url"},
+	}
+	for _, tt := range tests {
+		if got := CanonicalizeURL(tt.in); got != tt.want {
+			t.Errorf("CanonicalizeURL(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestLedger(t *testing.T) {
+	now := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	article := &Article{Title: "Go 1.22", Url: "https://example.com/go122?utm_source=rss", GUID: "guid-1", Content: "Range over  integers."}
+	ledger := NewLedger(nil)
+
+	entry := ledger.Seen(article, now)
+	if entry.URL != "https://example.com/go122" {
+		t.Errorf("entry url = %q", entry.URL)
+	}
+	if ledger.IsSummarized(article) {
+		t.Error("summarized before MarkSummarized")
+	}
+	if again := ledger.Seen(article, now.Add(time.Hour)); again.FirstSeenAt != now {
+		t.Errorf("first seen = %v, want %v", again.FirstSeenAt, now)
+	}
+
+	ledger.MarkSummarized("https://www.example.com/go122/", now)
+	for _, same := range []*Article{
+		{Url: "http://example.com/go122#top"},
+		{Url: "https://mirror.example.org/go122", GUID: "guid-1"},
+		{Title: "Go 1.22", Url: "https://mirror.example.org/other", Content: "Range over integers."},
+	} {
+		if !ledger.IsSummarized(same) {
+			t.Errorf("%+v is not recognized as summarized", same)
+		}
+	}
+	if ledger.IsSummarized(&Article{Url: "https://example.com/go123"}) {
+		t.Error("another article is recognized as summarized")
+	}
+
+	restored := NewLedger(ledger.Entries())
+	if !restored.IsSummarized(article) {
+		t.Error("the restored ledger lost the summarized article")
+	}
+}
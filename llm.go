@@ -0,0 +1,206 @@
+package artisum
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/samber/lo"
+	"github.com/tmc/langchaingo/callbacks"
+	"github.com/tmc/langchaingo/llms"
+	"github.com/tmc/langchaingo/llms/anthropic"
+	"github.com/tmc/langchaingo/llms/googleai"
+	"github.com/tmc/langchaingo/llms/ollama"
+	"github.com/tmc/langchaingo/llms/openai"
+)
+
+const defaultLLMProvider = "openai"
+
+// defaultLLMModels are used when a stage names a provider but no model, e.g. when only -provider is given.
+var defaultLLMModels = map[string]string{
+	"openai":    "gpt-4-turbo",
+	"anthropic": "claude-3-5-sonnet-20240620",
+	"ollama":    "llama3",
+	"googleai":  "gemini-1.5-flash",
+}
+
+type LLMConfig struct {
+	Provider string `json:"provider"`
+	Model    string `json:"model"`
+	// BaseURL is used to point a provider at a compatible endpoint, e.g. a local OpenAI-compatible server.
+	BaseURL   string `json:"baseUrl,omitempty"`
+	APIKeyEnv string `json:"apiKeyEnv,omitempty"`
+	// TokenBudget is the maximum number of input tokens in one prompt. Longer content is split into chunks.
+	// It defaults to 3/4 of the context window of the model, leaving the rest for the response.
+	TokenBudget int `json:"tokenBudget,omitempty"`
+}
+
+type LLMsConfig struct {
+	Select    *LLMConfig `json:"select,omitempty"`
+	Summarize *LLMConfig `json:"summarize,omitempty"`
+	// Repair is a cheaper model which fixes JSON the other stages got wrong. The stage itself repairs its output when it is not set.
+	Repair *LLMConfig `json:"repair,omitempty"`
+}
+
+type LLMProvider func(ctx context.Context, conf *LLMConfig, handler callbacks.Handler) (llms.Model, error)
+
+// LLMCapabilities are the ways a provider can constrain its output to JSON.
+type LLMCapabilities struct {
+	ToolCalling bool
+	JSONMode    bool
+}
+
+var llmProviders = map[string]LLMProvider{
+	"openai":    newOpenAILLM,
+	"anthropic": newAnthropicLLM,
+	"ollama":    newOllamaLLM,
+	"googleai":  newGoogleAILLM,
+}
+
+var llmCapabilities = map[string]LLMCapabilities{
+	"openai": {ToolCalling: true, JSONMode: true},
+	"ollama": {JSONMode: true},
+}
+
+func RegisterLLMProvider(name string, provider LLMProvider) {
+	llmProviders[name] = provider
+}
+
+// RegisterLLMCapabilities tells how a registered provider can constrain its output to JSON.
+// Without it the schema is only put in the prompt.
+func RegisterLLMCapabilities(name string, capabilities LLMCapabilities) {
+	llmCapabilities[name] = capabilities
+}
+
+func NewLLM(ctx context.Context, conf *LLMConfig, handler callbacks.Handler) (llms.Model, error) {
+	provider, ok := llmProviders[conf.Provider]
+	if !ok {
+		return nil, fmt.Errorf("unknown llm provider: %q", conf.Provider)
+	}
+	return provider(ctx, conf, handler)
+}
+
+// Override replaces the provider and model of the select and summarize stages when they are given.
+// When only the provider is changed, the stage gets the default model of the provider,
+// as the configured model and endpoint belong to the previous one.
+// The repair stage is left as is because it usually runs on a cheaper model.
+func (c *LLMsConfig) Override(provider, model string) {
+	for _, conf := range []*LLMConfig{c.Select, c.Summarize} {
+		if provider != "" && provider != conf.Provider {
+			*conf = LLMConfig{Provider: provider, Model: defaultLLMModels[provider]}
+		}
+		if model != "" {
+			conf.Model = model
+		}
+	}
+}
+
+func (c *LLMsConfig) setDefaults() {
+	if c.Select == nil {
+		c.Select = &LLMConfig{}
+	}
+	if c.Summarize == nil {
+		c.Summarize = &LLMConfig{}
+	}
+	for _, conf := range lo.Compact([]*LLMConfig{c.Select, c.Summarize, c.Repair}) {
+		if conf.Provider == "" {
+			conf.Provider = defaultLLMProvider
+		}
+		if conf.Model == "" {
+			conf.Model = defaultLLMModels[conf.Provider]
+		}
+	}
+}
+
+// modelContextSizes are matched by prefix, so the longest prefix has to come first.
+var modelContextSizes = []struct {
+	prefix string
+	size   int
+}{
+	{"gpt-4o", 128000},
+	{"gpt-4-turbo", 128000},
+	{"gpt-4-32k", 32768},
+	{"gpt-4", 8192},
+	{"gpt-3.5-turbo", 16385},
+	{"claude", 200000},
+	{"gemini-1.5", 1000000},
+	{"gemini", 32768},
+}
+
+func (c *LLMConfig) tokenBudget() int {
+	if c.TokenBudget > 0 {
+		return c.TokenBudget
+	}
+	size := llms.GetModelContextSize(c.Model)
+	for _, m := range modelContextSizes {
+		if strings.HasPrefix(c.Model, m.prefix) {
+			size = m.size
+			break
+		}
+	}
+	return size * 3 / 4
+}
+
+func (c *LLMConfig) apiKey() string {
+	if c.APIKeyEnv == "" {
+		return ""
+	}
+	return os.Getenv(c.APIKeyEnv)
+}
+
+func newOpenAILLM(_ context.Context, conf *LLMConfig, handler callbacks.Handler) (llms.Model, error) {
+	opts := []openai.Option{openai.WithModel(conf.Model)}
+	if conf.BaseURL != "" {
+		opts = append(opts, openai.WithBaseURL(conf.BaseURL))
+	}
+	if key := conf.apiKey(); key != "" {
+		opts = append(opts, openai.WithToken(key))
+	}
+	if handler != nil {
+		opts = append(opts, openai.WithCallback(handler))
+	}
+	return openai.New(opts...)
+}
+
+func newAnthropicLLM(_ context.Context, conf *LLMConfig, handler callbacks.Handler) (llms.Model, error) {
+	opts := []anthropic.Option{anthropic.WithModel(conf.Model)}
+	if conf.BaseURL != "" {
+		opts = append(opts, anthropic.WithBaseURL(conf.BaseURL))
+	}
+	if key := conf.apiKey(); key != "" {
+		opts = append(opts, anthropic.WithToken(key))
+	}
+	llm, err := anthropic.New(opts...)
+	if err != nil {
+		return nil, err
+	}
+	llm.CallbacksHandler = handler
+	return llm, nil
+}
+
+func newOllamaLLM(_ context.Context, conf *LLMConfig, handler callbacks.Handler) (llms.Model, error) {
+	opts := []ollama.Option{ollama.WithModel(conf.Model)}
+	if conf.BaseURL != "" {
+		opts = append(opts, ollama.WithServerURL(conf.BaseURL))
+	}
+	llm, err := ollama.New(opts...)
+	if err != nil {
+		return nil, err
+	}
+	llm.CallbacksHandler = handler
+	return llm, nil
+}
+
+func newGoogleAILLM(ctx context.Context, conf *LLMConfig, handler callbacks.Handler) (llms.Model, error) {
+	key := conf.apiKey()
+	if key == "" {
+		key = os.Getenv("GOOGLE_API_KEY")
+	}
+	llm, err := googleai.New(ctx, googleai.WithDefaultModel(conf.Model), googleai.WithAPIKey(key))
+	if err != nil {
+		return nil, err
+	}
+	llm.CallbacksHandler = handler
+	return llm, nil
+}
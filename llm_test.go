@@ -0,0 +1,77 @@
+package artisum
+
+import "testing"
+
+func TestLLMsConfigOverride(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider string
+		model    string
+		want     LLMConfig
+	}{
+		{
+			name: "nothing given",
+			want: LLMConfig{Provider: "openai", Model: "gpt-4o", BaseURL: "http://localhost:8080/v1", APIKeyEnv: "LOCAL_KEY"},
+		},
+		{
+			name:  "model only",
+			model: "gpt-4o-mini",
+			want:  LLMConfig{Provider: "openai", Model: "gpt-4o-mini", BaseURL: "http://localhost:8080/v1", APIKeyEnv: "LOCAL_KEY"},
+		},
+		{
+			name:     "same provider",
+			provider: "openai",
+			want:     LLMConfig{Provider: "openai", Model: "gpt-4o", BaseURL: "http://localhost:8080/v1", APIKeyEnv: "LOCAL_KEY"},
+		},
+		{
+			name:     "provider only",
+			provider: "ollama",
+			want:     LLMConfig{Provider: "ollama", Model: "llama3"},
+		},
+		{
+			name:     "provider and model",
+			provider: "anthropic",
+			model:    "claude-3-haiku-20240307",
+			want:     LLMConfig{Provider: "anthropic", Model: "claude-3-haiku-20240307"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := &LLMsConfig{
+				Select:    &LLMConfig{Provider: "openai", Model: "gpt-4o", BaseURL: "http://localhost:8080/v1", APIKeyEnv: "LOCAL_KEY"},
+				Summarize: &LLMConfig{Provider: "openai", Model: "gpt-4o", BaseURL: "http://localhost:8080/v1", APIKeyEnv: "LOCAL_KEY"},
+				Repair:    &LLMConfig{Provider: "openai", Model: "gpt-3.5-turbo"},
+			}
+			conf.Override(tt.provider, tt.model)
+			if want := (LLMConfig{Provider: "openai", Model: "gpt-3.5-turbo"}); *conf.Repair != want {
+				t.Errorf("repair = %+v, want %+v", *conf.Repair, want)
+			}
+			for _, got := range []*LLMConfig{conf.Select, conf.Summarize} {
+				if *got != tt.want {
+					t.Errorf("got %+v, want %+v", *got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestLLMsConfigSetDefaults(t *testing.T) {
+	conf := &LLMsConfig{Summarize: &LLMConfig{Provider: "googleai"}}
+	conf.setDefaults()
+	if conf.Select.Provider != "openai" || conf.Select.Model != "gpt-4-turbo" {
+		t.Errorf("select = %+v", *conf.Select)
+	}
+	if conf.Summarize.Model != "gemini-1.5-flash" {
+		t.Errorf("summarize = %+v", *conf.Summarize)
+	}
+	// Without a repair stage the other stages repair their own output.
+	if conf.Repair != nil {
+		t.Errorf("repair = %+v", *conf.Repair)
+	}
+
+	conf = &LLMsConfig{Repair: &LLMConfig{Provider: "ollama"}}
+	conf.setDefaults()
+	if conf.Repair.Model != "llama3" {
+		t.Errorf("repair = %+v", *conf.Repair)
+	}
+}
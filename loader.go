@@ -1,47 +1,509 @@
 package artisum
 
 import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
 	"io"
+	"math"
 	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/ledongthuc/pdf"
 	"golang.org/x/net/html"
 )
 
-func ExtractTextContentFromURL(url string) (string, error) {
-	h, err := http.Get(url)
+const pptxMediaType = "application/vnd.openxmlformats-officedocument.presentationml.presentation"
+
+type contentExtractor func(body []byte, u *url.URL) (*PageContent, error)
+
+// contentExtractors are keyed by media type. Servers often send Markdown as text/plain,
+// so extractorFor also looks at the file extension.
+var contentExtractors = map[string]contentExtractor{
+	"text/html":             extractHTMLContent,
+	"application/xhtml+xml": extractHTMLContent,
+	"application/pdf":       extractPDFContent,
+	pptxMediaType:           extractPPTXContent,
+	"text/markdown":         extractMarkdownContent,
+	"text/x-markdown":       extractMarkdownContent,
+	"text/plain":            extractPlainTextContent,
+}
+
+var loaderAcceptTypes = []string{
+	"text/html", "application/xhtml+xml", "application/pdf", pptxMediaType,
+	"text/markdown", "text/x-markdown", "text/plain", "application/octet-stream", "application/zip",
+}
+
+// ExtractContentFromURL fetches rawURL and extracts its main content according to the Content-Type,
+// so that HTML pages, PDFs (papers and slide decks), PowerPoint decks, Markdown and plain text all become clean text.
+// GitHub repository, file and gist pages are read from their raw Markdown or text.
+// Keynote files are not supported and fail with ErrUnsupportedContentType; decks shared as PDF are.
+func ExtractContentFromURL(ctx context.Context, fetcher *Fetcher, rawURL string) (*PageContent, error) {
+	u, err := url.Parse(rawURL)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+
+	sources := rawSourceURLs(u)
+	if len(sources) == 0 {
+		return extractContent(ctx, fetcher, rawURL, true)
 	}
-	defer h.Body.Close()
 
-	body, err := io.ReadAll(h.Body)
+	// The raw hosts disallow crawlers, so robots.txt is checked against the page the feed links to.
+	if err := fetcher.checkRobots(ctx, u); err != nil {
+		return nil, err
+	}
+	for _, source := range sources {
+		content, err := extractContent(ctx, fetcher, source, false)
+		var statusErr *HTTPStatusError
+		if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+			continue
+		}
+		return content, err
+	}
+	return extractContent(ctx, fetcher, rawURL, false)
+}
+
+func ExtractTextContentFromURL(ctx context.Context, fetcher *Fetcher, url string) (string, error) {
+	content, err := ExtractContentFromURL(ctx, fetcher, url)
 	if err != nil {
 		return "", err
 	}
+	return content.Text, nil
+}
 
-	parsed, err := html.Parse(strings.NewReader(string(body)))
+func extractContent(ctx context.Context, fetcher *Fetcher, rawURL string, checkRobots bool) (*PageContent, error) {
+	resp, err := fetcher.Fetch(ctx, rawURL, &FetchOptions{
+		AcceptTypes:   loaderAcceptTypes,
+		CheckRobots:   checkRobots,
+		DecodeCharset: true,
+	})
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	buf := &strings.Builder{}
+	extract := extractorFor(resp)
+	if extract == nil {
+		return nil, fmt.Errorf("%w: %s (%s)", ErrUnsupportedContentType, resp.MediaType, rawURL)
+	}
+	return extract(resp.Body, resp.URL)
+}
+
+// extractorFor picks the extractor by the media type, looking at the body and the file extension
+// when the server does not tell the type. A ZIP archive is never parsed as HTML.
+func extractorFor(resp *FetchResponse) contentExtractor {
+	mediaType := resp.MediaType
+	isZip := bytes.HasPrefix(resp.Body, []byte("PK\x03\x04"))
+	switch {
+	case isZip && (mediaType == pptxMediaType || strings.EqualFold(path.Ext(resp.URL.Path), ".pptx") || isPPTX(resp.Body)):
+		return extractPPTXContent
+	case isZip:
+		return nil
+	case mediaType == "application/octet-stream":
+		if bytes.HasPrefix(resp.Body, []byte("%PDF-")) {
+			return extractPDFContent
+		}
+	case mediaType == "text/plain" && isMarkdownPath(resp.URL.Path):
+		return extractMarkdownContent
+	}
+	return contentExtractors[mediaType]
+}
+
+// rawSourceURLs returns the raw URLs to read instead of a GitHub page, in the order to try.
+// It returns nil for other URLs.
+func rawSourceURLs(u *url.URL) []string {
+	segments := strings.FieldsFunc(u.Path, func(r rune) bool { return r == '/' })
+	switch strings.TrimPrefix(strings.ToLower(u.Host), "www.") {
+	case "github.com":
+		if len(segments) < 2 {
+			return nil
+		}
+		owner, repo := segments[0], segments[1]
+		base := "https://raw.githubusercontent.com/" + owner + "/" + repo + "/"
+		switch {
+		case len(segments) == 2:
+			return readmeURLs(base + "HEAD")
+		case len(segments) >= 4 && segments[2] == "tree":
+			return readmeURLs(base + strings.Join(segments[3:], "/"))
+		case len(segments) >= 5 && segments[2] == "blob":
+			return []string{base + strings.Join(segments[3:], "/")}
+		}
+	case "gist.github.com":
+		if len(segments) == 2 {
+			return []string{"https://gist.githubusercontent.com/" + segments[0] + "/" + segments[1] + "/raw"}
+		}
+	}
+	return nil
+}
 
-	var f func(*html.Node) error
-	f = func(n *html.Node) error {
-		if n.Type == html.TextNode && n.Parent.Data != "script" && n.Parent.Data != "style" {
-			if _, err := buf.WriteString(strings.TrimSpace(n.Data)); err != nil {
-				return err
+func readmeURLs(dir string) []string {
+	return []string{dir + "/README.md", dir + "/readme.md", dir + "/README"}
+}
+
+func extractHTMLContent(body []byte, u *url.URL) (*PageContent, error) {
+	parsed, err := html.Parse(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	return ExtractPageContent(parsed, u), nil
+}
+
+// extractPDFContent reads the text of each page. Each page becomes a section so that slide decks keep their structure.
+func extractPDFContent(body []byte, _ *url.URL) (content *PageContent, err error) {
+	// The pdf package panics on some malformed files.
+	defer func() {
+		if r := recover(); r != nil {
+			content, err = nil, fmt.Errorf("failed to read pdf: %v", r)
+		}
+	}()
+
+	reader, err := pdf.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return nil, err
+	}
+
+	content = &PageContent{}
+	info := reader.Trailer().Key("Info")
+	content.Title = strings.TrimSpace(info.Key("Title").Text())
+	content.Author = strings.TrimSpace(info.Key("Author").Text())
+	content.PublishedAt = parsePDFDate(info.Key("CreationDate").Text())
+
+	var b strings.Builder
+	for i := 1; i <= reader.NumPage(); i++ {
+		page := reader.Page(i)
+		if page.V.IsNull() {
+			continue
+		}
+		lines := pdfPageLines(page)
+		if len(lines) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "# Page %d\n%s\n\n", i, strings.Join(lines, "\n"))
+	}
+
+	content.Text = strings.TrimSpace(b.String())
+	if content.Text == "" {
+		return nil, errors.New("no text is found in pdf")
+	}
+	return content, nil
+}
+
+// pdfPageLines groups the glyphs of the page into lines by their baseline, inserting a space where glyphs are apart.
+func pdfPageLines(page pdf.Page) []string {
+	var (
+		lines []string
+		line  strings.Builder
+		prev  *pdf.Text
+	)
+	flush := func() {
+		if s := collapseSpaces(line.String()); s != "" {
+			lines = append(lines, s)
+		}
+		line.Reset()
+	}
+	for _, text := range page.Content().Text {
+		if prev != nil {
+			size := max(prev.FontSize, 1)
+			switch {
+			case math.Abs(text.Y-prev.Y) > size/2:
+				flush()
+			case text.X-(prev.X+prev.W) > size/5:
+				line.WriteString(" ")
 			}
 		}
-		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			if err = f(c); err != nil {
-				return err
+		line.WriteString(text.S)
+		prev = &text
+	}
+	flush()
+	return lines
+}
+
+// parsePDFDate parses dates like "D:20240102150405+09'00'".
+func parsePDFDate(s string) time.Time {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "D:")
+	s = strings.ReplaceAll(s, "'", "")
+	for _, layout := range []string{"20060102150405Z0700", "20060102150405Z07", "20060102150405", "20060102"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+const drawingMLNamespace = "http://schemas.openxmlformats.org/drawingml/2006/main"
+
+// isPPTX reports whether the ZIP archive is a PowerPoint deck.
+func isPPTX(body []byte) bool {
+	reader, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return false
+	}
+	for _, f := range reader.File {
+		if f.Name == "ppt/presentation.xml" {
+			return true
+		}
+	}
+	return false
+}
+
+// extractPPTXContent reads the text of each slide in the order of the deck. Each slide becomes a section like the pages of a PDF.
+func extractPPTXContent(body []byte, _ *url.URL) (*PageContent, error) {
+	reader, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]*zip.File, len(reader.File))
+	for _, f := range reader.File {
+		files[f.Name] = f
+	}
+	if files["ppt/presentation.xml"] == nil {
+		return nil, fmt.Errorf("%w: zip archive which is not a pptx", ErrUnsupportedContentType)
+	}
+
+	content := &PageContent{}
+	if f := files["docProps/core.xml"]; f != nil {
+		var props struct {
+			Title   string `xml:"title"`
+			Creator string `xml:"creator"`
+			Created string `xml:"created"`
+		}
+		if data, err := readZipFile(f); err == nil && xml.Unmarshal(data, &props) == nil {
+			content.Title = strings.TrimSpace(props.Title)
+			content.Author = strings.TrimSpace(props.Creator)
+			content.PublishedAt = parseDatetime(props.Created)
+		}
+	}
+
+	var b strings.Builder
+	for i, name := range pptxSlideNames(files) {
+		f := files[name]
+		if f == nil {
+			continue
+		}
+		data, err := readZipFile(f)
+		if err != nil {
+			return nil, err
+		}
+		lines, err := pptxSlideLines(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		if len(lines) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "# Slide %d\n%s\n\n", i+1, strings.Join(lines, "\n"))
+	}
+
+	content.Text = strings.TrimSpace(b.String())
+	if content.Text == "" {
+		return nil, errors.New("no text is found in pptx")
+	}
+	return content, nil
+}
+
+// pptxSlideNames returns the slide files in the order of the slide list of the presentation.
+// It falls back to the order of the slide numbers when the list cannot be read.
+func pptxSlideNames(files map[string]*zip.File) []string {
+	var presentation struct {
+		Slides []struct {
+			ID string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sldIdLst>sldId"`
+	}
+	var rels struct {
+		Relationships []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	if f, r := files["ppt/presentation.xml"], files["ppt/_rels/presentation.xml.rels"]; f != nil && r != nil {
+		data, err := readZipFile(f)
+		relsData, relsErr := readZipFile(r)
+		if err == nil && relsErr == nil && xml.Unmarshal(data, &presentation) == nil && xml.Unmarshal(relsData, &rels) == nil {
+			targets := make(map[string]string, len(rels.Relationships))
+			for _, rel := range rels.Relationships {
+				targets[rel.ID] = path.Join("ppt", rel.Target)
+			}
+			var names []string
+			for _, slide := range presentation.Slides {
+				if target, ok := targets[slide.ID]; ok {
+					names = append(names, target)
+				}
+			}
+			if len(names) > 0 {
+				return names
 			}
 		}
-		return nil
 	}
-	f(parsed)
 
-	return buf.String(), nil
+	var names []string
+	for name := range files {
+		if path.Dir(name) == "ppt/slides" && path.Ext(name) == ".xml" {
+			names = append(names, name)
+		}
+	}
+	number := func(name string) int {
+		n, _ := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(path.Base(name), "slide"), ".xml"))
+		return n
+	}
+	sort.Slice(names, func(i, j int) bool { return number(names[i]) < number(names[j]) })
+	return names
+}
+
+// pptxSlideLines returns the paragraphs of the text runs of the slide.
+func pptxSlideLines(data []byte) ([]string, error) {
+	var (
+		lines     []string
+		paragraph strings.Builder
+		inText    bool
+	)
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			return lines, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			if t.Name.Space != drawingMLNamespace {
+				continue
+			}
+			switch t.Name.Local {
+			case "t":
+				inText = true
+			case "br":
+				paragraph.WriteString(" ")
+			}
+		case xml.EndElement:
+			if t.Name.Space != drawingMLNamespace {
+				continue
+			}
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				if s := collapseSpaces(paragraph.String()); s != "" {
+					lines = append(lines, s)
+				}
+				paragraph.Reset()
+			}
+		case xml.CharData:
+			if inText {
+				paragraph.Write(t)
+			}
+		}
+	}
+}
+
+func readZipFile(f *zip.File) ([]byte, error) {
+	r, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+var (
+	markdownImageRegexp   = regexp.MustCompile(`!\[([^\]]*)\]\([^)]*\)`)
+	markdownLinkRegexp    = regexp.MustCompile(`\[([^\]]*)\]\([^)]*\)`)
+	markdownRefLinkRegexp = regexp.MustCompile(`^\s*\[[^\]]+\]:\s*\S+`)
+	htmlCommentRegexp     = regexp.MustCompile(`(?s)<!--.*?-->`)
+	htmlTagRegexp         = regexp.MustCompile(`</?[a-zA-Z][^>]*>`)
+	blankLinesRegexp      = regexp.MustCompile(`\n{3,}`)
+)
+
+// extractMarkdownContent keeps the Markdown structure, which already matches PageContent.Text,
+// and drops front matter, link targets, images and inline HTML outside code blocks.
+func extractMarkdownContent(body []byte, _ *url.URL) (*PageContent, error) {
+	content := &PageContent{}
+	text := strings.ReplaceAll(string(body), "\r\n", "\n")
+	text = strings.TrimPrefix(text, "\ufeff")
+	text = readFrontMatter(text, content)
+	text = htmlCommentRegexp.ReplaceAllString(text, "")
+
+	var (
+		lines   []string
+		inFence bool
+	)
+	for _, line := range strings.Split(text, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") || strings.HasPrefix(strings.TrimSpace(line), "~~~") {
+			inFence = !inFence
+			lines = append(lines, strings.TrimSpace(line))
+			continue
+		}
+		if inFence {
+			lines = append(lines, line)
+			continue
+		}
+		if markdownRefLinkRegexp.MatchString(line) {
+			continue
+		}
+		line = markdownImageRegexp.ReplaceAllString(line, "$1")
+		line = markdownLinkRegexp.ReplaceAllString(line, "$1")
+		line = strings.TrimRight(htmlTagRegexp.ReplaceAllString(line, ""), " \t")
+		if content.Title == "" && strings.HasPrefix(line, "# ") {
+			content.Title = strings.TrimSpace(strings.TrimPrefix(line, "# "))
+		}
+		lines = append(lines, line)
+	}
+
+	content.Text = strings.TrimSpace(blankLinesRegexp.ReplaceAllString(strings.Join(lines, "\n"), "\n\n"))
+	return content, nil
+}
+
+// readFrontMatter reads title, author and date from YAML front matter and returns the text without it.
+func readFrontMatter(text string, content *PageContent) string {
+	if !strings.HasPrefix(text, "---\n") {
+		return text
+	}
+	frontMatter, rest, ok := strings.Cut(strings.TrimPrefix(text, "---\n"), "\n---")
+	if !ok {
+		return text
+	}
+
+	for _, line := range strings.Split(frontMatter, "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.Trim(strings.TrimSpace(value), `"'`)
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "title":
+			content.Title = value
+		case "author":
+			content.Author = value
+		case "date", "published", "published_at":
+			content.PublishedAt = parseDatetime(value)
+		}
+	}
+	_, rest, _ = strings.Cut(rest, "\n")
+	return rest
+}
+
+func extractPlainTextContent(body []byte, _ *url.URL) (*PageContent, error) {
+	text := strings.ReplaceAll(string(body), "\r\n", "\n")
+	text = strings.TrimPrefix(text, "\ufeff")
+	return &PageContent{
+		Text: strings.TrimSpace(blankLinesRegexp.ReplaceAllString(text, "\n\n")),
+	}, nil
+}
+
+func isMarkdownPath(p string) bool {
+	switch strings.ToLower(path.Ext(p)) {
+	case ".md", ".markdown", ".mdown", ".mkd":
+		return true
+	}
+	return false
 }
@@ -0,0 +1,138 @@
+package artisum
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testSlideXML = `<?xml version="1.0" encoding="UTF-8"?>
+<p:sld xmlns:a="http://schemas.openxmlformats.org/drawingml/2006/main" xmlns:p="http://schemas.openxmlformats.org/presentationml/2006/main">
+<p:cSld><p:spTree>
+<p:sp><p:txBody><a:p><a:r><a:t>%s</a:t></a:r></a:p></p:txBody></p:sp>
+<p:sp><p:txBody><a:p><a:r><a:t>first </a:t></a:r><a:r><a:t>run</a:t></a:r><a:br/><a:r><a:t>next line</a:t></a:r></a:p><a:p></a:p></p:txBody></p:sp>
+</p:spTree></p:cSld>
+</p:sld>`
+
+// testPPTX builds a deck whose slide list puts slide2.xml before slide1.xml.
+func testPPTX(t *testing.T) []byte {
+	t.Helper()
+	files := map[string]string{
+		"docProps/core.xml": `<cp:coreProperties xmlns:cp="http://schemas.openxmlformats.org/package/2006/metadata/core-properties" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:dcterms="http://purl.org/dc/terms/">
+<dc:title>Go 1.23 の新機能</dc:title><dc:creator>gopher</dc:creator><dcterms:created>2024-08-13T10:00:00Z</dcterms:created></cp:coreProperties>`,
+		"ppt/presentation.xml": `<p:presentation xmlns:p="http://schemas.openxmlformats.org/presentationml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<p:sldIdLst><p:sldId id="256" r:id="rId3"/><p:sldId id="257" r:id="rId2"/></p:sldIdLst></p:presentation>`,
+		"ppt/_rels/presentation.xml.rels": `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId2" Target="slides/slide1.xml"/><Relationship Id="rId3" Target="slides/slide2.xml"/></Relationships>`,
+		"ppt/slides/slide1.xml": fmt.Sprintf(testSlideXML, "range over func"),
+		"ppt/slides/slide2.xml": fmt.Sprintf(testSlideXML, "Go 1.23"),
+	}
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, content := range files {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestExtractPPTXContent(t *testing.T) {
+	content, err := extractPPTXContent(testPPTX(t), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "# Slide 1\nGo 1.23\nfirst run next line\n\n# Slide 2\nrange over func\nfirst run next line"
+	if content.Text != want {
+		t.Errorf("text = %q, want %q", content.Text, want)
+	}
+	if content.Title != "Go 1.23 の新機能" || content.Author != "gopher" || content.PublishedAt.IsZero() {
+		t.Errorf("metadata = %q, %q, %v", content.Title, content.Author, content.PublishedAt)
+	}
+}
+
+func TestExtractorFor(t *testing.T) {
+	pptx := testPPTX(t)
+	var notPPTX bytes.Buffer
+	w := zip.NewWriter(&notPPTX)
+	if _, err := w.Create("Index/Document.iwa"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		mediaType string
+		path      string
+		body      []byte
+		want      string
+	}{
+		{"pptx", pptxMediaType, "/deck", pptx, "pptx"},
+		{"pptx as octet-stream", "application/octet-stream", "/deck.pptx", pptx, "pptx"},
+		{"pptx as html", "text/html", "/slides/1", pptx, "pptx"},
+		{"other zip as html", "text/html", "/deck.key", notPPTX.Bytes(), ""},
+		{"pdf as octet-stream", "application/octet-stream", "/paper", []byte("%PDF-1.7"), "pdf"},
+		{"markdown as text", "text/plain", "/README.md", []byte("# title"), "markdown"},
+		{"html", "text/html", "/post", []byte("<html></html>"), "html"},
+		{"keynote", "application/octet-stream", "/deck.key", []byte("IWA"), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &FetchResponse{MediaType: tt.mediaType, URL: &url.URL{Path: tt.path}, Body: tt.body}
+			if got := extractorName(extractorFor(resp)); got != tt.want {
+				t.Errorf("extractor = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// extractorName names the extractor by its function pointer, since functions cannot be compared.
+func extractorName(extract contentExtractor) string {
+	if extract == nil {
+		return ""
+	}
+	for name, known := range map[string]contentExtractor{
+		"pptx": extractPPTXContent, "pdf": extractPDFContent, "markdown": extractMarkdownContent, "html": extractHTMLContent,
+	} {
+		if reflect.ValueOf(known).Pointer() == reflect.ValueOf(extract).Pointer() {
+			return name
+		}
+	}
+	return "unknown"
+}
+
+func TestExtractContentWithoutContentType(t *testing.T) {
+	pptx := testPPTX(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header()["Content-Type"] = nil
+		_, _ = w.Write(pptx)
+	}))
+	defer server.Close()
+
+	content, err := ExtractContentFromURL(context.Background(), newTestFetcher(), server.URL+"/slides/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(content.Text, "# Slide 1\nGo 1.23\n") {
+		t.Errorf("text = %q", content.Text)
+	}
+}
@@ -2,28 +2,112 @@ package artisum
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
 
 	"github.com/jomei/notionapi"
 )
 
+// These properties are written only when the database has them, as they are not part of the original database.
+const (
+	notionPromptVersionProperty = "プロンプト"
+	// notionReasonProperty is a rich text with the selection score and reason.
+	notionReasonProperty = "選定理由"
+)
+
+// notionTextLimit is the maximum length of a rich text content in the Notion API.
+const notionTextLimit = 2000
+
 type NotionRepository struct {
-	client     *notionapi.Client
-	databaseId notionapi.DatabaseID
+	client       *notionapi.Client
+	databaseId   notionapi.DatabaseID
+	summary      *SummarySchema
+	propertiesMu sync.Mutex
+	properties   notionapi.PropertyConfigs
 }
 
-func NewNotionRepository(token, databaseId string) *NotionRepository {
+func NewNotionRepository(token, databaseId string, summary *SummarySchema) *NotionRepository {
 	return &NotionRepository{
 		client:     notionapi.NewClient(notionapi.Token(token)),
 		databaseId: notionapi.DatabaseID(databaseId),
+		summary:    summary,
+	}
+}
+
+// SaveSummaryResult creates a page for the article and returns its URL.
+func (r *NotionRepository) SaveSummaryResult(ctx context.Context, article *SummaryArticle) (string, error) {
+	properties, err := r.databaseProperties(ctx)
+	if err != nil {
+		return "", err
+	}
+	page, err := r.client.Page.Create(ctx, r.createPageRequest(article, properties))
+	if err != nil {
+		return "", err
+	}
+	return page.URL, nil
+}
+
+// databaseProperties returns the property configs of the database, fetched once.
+func (r *NotionRepository) databaseProperties(ctx context.Context) (notionapi.PropertyConfigs, error) {
+	r.propertiesMu.Lock()
+	defer r.propertiesMu.Unlock()
+
+	if r.properties != nil {
+		return r.properties, nil
+	}
+	db, err := r.client.Database.Get(ctx, r.databaseId)
+	if err != nil {
+		return nil, err
+	}
+	r.properties = db.Properties
+	return r.properties, nil
+}
+
+// ExistsArticle reports whether a page whose "記事" property points to the given URL already exists.
+func (r *NotionRepository) ExistsArticle(ctx context.Context, url string) (bool, error) {
+	filter := notionapi.OrCompoundFilter{newNotionURLFilter("記事", url)}
+	if canonical := CanonicalizeURL(url); canonical != url {
+		filter = append(filter, newNotionURLFilter("記事", canonical))
+	}
+
+	resp, err := r.client.Database.Query(ctx, r.databaseId, &notionapi.DatabaseQueryRequest{
+		Filter:   filter,
+		PageSize: 1,
+	})
+	if err != nil {
+		return false, err
 	}
+	return len(resp.Results) > 0, nil
 }
 
-func (r *NotionRepository) SaveSummaryResult(ctx context.Context, article *SummaryArticle) error {
-	_, err := r.client.Page.Create(ctx, r.createPageRequest(article))
-	return err
+// notionURLFilter is a filter on a URL property, which notionapi.PropertyFilter does not have a field for.
+// Notion rejects a rich_text filter on a URL property. PropertyFilter is embedded only to implement notionapi.Filter.
+type notionURLFilter struct {
+	notionapi.PropertyFilter
+	URL *notionapi.TextFilterCondition
+}
+
+func newNotionURLFilter(property, url string) notionURLFilter {
+	return notionURLFilter{
+		PropertyFilter: notionapi.PropertyFilter{Property: property},
+		URL:            &notionapi.TextFilterCondition{Equals: url},
+	}
 }
 
-func (r *NotionRepository) createPageRequest(article *SummaryArticle) *notionapi.PageCreateRequest {
+func (f notionURLFilter) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Property string                         `json:"property"`
+		URL      *notionapi.TextFilterCondition `json:"url"`
+	}{
+		Property: f.Property,
+		URL:      f.URL,
+	})
+}
+
+func (r *NotionRepository) createPageRequest(article *SummaryArticle, properties notionapi.PropertyConfigs) *notionapi.PageCreateRequest {
 	req := &notionapi.PageCreateRequest{
 		Parent: notionapi.Parent{
 			Type:       notionapi.ParentTypeDatabaseID,
@@ -54,10 +138,91 @@ func (r *NotionRepository) createPageRequest(article *SummaryArticle) *notionapi
 		},
 		Children: r.createPageChildren(article.Contents),
 	}
+	if config, ok := properties[notionPromptVersionProperty]; ok && config.GetType() == notionapi.PropertyConfigTypeRichText && article.PromptVersion != "" {
+		req.Properties[notionPromptVersionProperty] = notionRichTextProperty(article.PromptVersion)
+	}
+	if config, ok := properties[notionReasonProperty]; ok && config.GetType() == notionapi.PropertyConfigTypeRichText && article.Origin.Reason != "" {
+		req.Properties[notionReasonProperty] = notionRichTextProperty(fmt.Sprintf("%d/%d %s", article.Origin.Score, maxSelectionScore, article.Origin.Reason))
+	}
+	for _, c := range article.Contents {
+		if name, property := r.summaryProperty(c, properties); property != nil {
+			req.Properties[name] = property
+		}
+	}
 
 	return req
 }
 
+// summaryProperty returns the typed property for a summary field which has NotionProperty.
+// Nothing is returned when the database does not have the property or its type does not match the field.
+func (r *NotionRepository) summaryProperty(content *FormatContent, properties notionapi.PropertyConfigs) (string, notionapi.Property) {
+	field := r.summary.field(content.Field)
+	if field == nil || field.NotionProperty == "" {
+		return "", nil
+	}
+	config, ok := properties[field.NotionProperty]
+	if !ok {
+		slog.Warn("notion property is not found", slog.String("property", field.NotionProperty))
+		return "", nil
+	}
+
+	var (
+		property     notionapi.Property
+		expectedType notionapi.PropertyConfigType
+	)
+	switch field.Type {
+	case SummaryFieldText:
+		expectedType = notionapi.PropertyConfigTypeRichText
+		property = notionRichTextProperty(strings.Join(content.Sentences, "\n"))
+	case SummaryFieldList:
+		expectedType = notionapi.PropertyConfigTypeMultiSelect
+		options := make([]notionapi.Option, 0, len(content.Sentences))
+		for _, s := range content.Sentences {
+			// Commas are not allowed in option names.
+			if name := strings.TrimSpace(strings.ReplaceAll(s, ",", " ")); name != "" {
+				options = append(options, notionapi.Option{Name: truncate(name, 100)})
+			}
+		}
+		property = notionapi.MultiSelectProperty{Type: notionapi.PropertyTypeMultiSelect, MultiSelect: options}
+	case SummaryFieldEnum:
+		expectedType = notionapi.PropertyConfigTypeSelect
+		property = notionapi.SelectProperty{Type: notionapi.PropertyTypeSelect, Select: notionapi.Option{Name: content.Option}}
+	case SummaryFieldRating:
+		expectedType = notionapi.PropertyConfigTypeNumber
+		if content.Rating == nil {
+			return "", nil
+		}
+		property = notionapi.NumberProperty{Type: notionapi.PropertyTypeNumber, Number: *content.Rating}
+	}
+	if config.GetType() != expectedType {
+		slog.Warn("notion property type does not match the summary field",
+			slog.String("property", field.NotionProperty), slog.String("type", string(config.GetType())), slog.String("expected", string(expectedType)))
+		return "", nil
+	}
+	return field.NotionProperty, property
+}
+
+func notionRichTextProperty(content string) notionapi.RichTextProperty {
+	return notionapi.RichTextProperty{
+		Type: notionapi.PropertyTypeRichText,
+		RichText: []notionapi.RichText{
+			{
+				Type: notionapi.ObjectTypeText,
+				Text: &notionapi.Text{
+					Content: truncate(content, notionTextLimit),
+				},
+			},
+		},
+	}
+}
+
+func truncate(s string, n int) string {
+	if r := []rune(s); len(r) > n {
+		return string(r[:n])
+	}
+	return s
+}
+
 func (r *NotionRepository) createPageChildren(contents []*FormatContent) []notionapi.Block {
 	var blocks []notionapi.Block
 	for _, c := range contents {
@@ -78,6 +243,30 @@ func (r *NotionRepository) createPageChildren(contents []*FormatContent) []notio
 				Type:   notionapi.BlockTypeHeading2,
 			},
 		}
+		blocks = append(blocks, headingBlock)
+
+		if c.Type == SummaryFieldList {
+			for _, s := range c.Sentences {
+				blocks = append(blocks, &notionapi.BulletedListItemBlock{
+					BulletedListItem: notionapi.ListItem{
+						RichText: []notionapi.RichText{
+							{
+								Type: notionapi.ObjectTypeText,
+								Text: &notionapi.Text{
+									Content: s,
+								},
+							},
+						},
+					},
+					BasicBlock: notionapi.BasicBlock{
+						Object: notionapi.ObjectTypeBlock,
+						Type:   notionapi.BlockTypeBulletedListItem,
+					},
+				})
+			}
+			continue
+		}
+
 		paragraphTexts := make([]notionapi.RichText, 0, len(c.Sentences))
 		for _, s := range c.Sentences {
 			paragraphTexts = append(paragraphTexts, notionapi.RichText{
@@ -97,7 +286,7 @@ func (r *NotionRepository) createPageChildren(contents []*FormatContent) []notio
 			},
 		}
 
-		blocks = append(blocks, headingBlock, paragraphBlock)
+		blocks = append(blocks, paragraphBlock)
 	}
 	return blocks
 }
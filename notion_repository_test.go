@@ -0,0 +1,107 @@
+package artisum
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/jomei/notionapi"
+)
+
+// notionStub redirects the requests of a notionapi client to handler.
+func notionStub(t *testing.T, handler http.HandlerFunc) *notionapi.Client {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	serverURL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return notionapi.NewClient("token", notionapi.WithHTTPClient(&http.Client{
+		Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			req.URL.Scheme = serverURL.Scheme
+			req.URL.Host = serverURL.Host
+			return http.DefaultTransport.RoundTrip(req)
+		}),
+	}))
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestNotionRepositoryExistsArticle(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		results string
+		want    bool
+		filters []string
+	}{
+		{
+			name:    "not found",
+			url:     "https://example.com/posts/1",
+			results: `[]`,
+			want:    false,
+			filters: []string{"https://example.com/posts/1"},
+		},
+		{
+			name:    "found by the canonical url",
+			url:     "http://www.example.com/posts/1/?utm_source=rss",
+			results: `[{"object":"page","id":"page-1"}]`,
+			want:    true,
+			filters: []string{"http://www.example.com/posts/1/?utm_source=rss", "https://example.com/posts/1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body []byte
+			client := notionStub(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodPost || r.URL.Path != "/v1/databases/db/query" {
+					t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+				}
+				body, _ = io.ReadAll(r.Body)
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = io.WriteString(w, `{"object":"list","results":`+tt.results+`,"has_more":false}`)
+			})
+			repo := &NotionRepository{client: client, databaseId: "db"}
+
+			got, err := repo.ExistsArticle(context.Background(), tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+
+			var req struct {
+				Filter struct {
+					Or []map[string]json.RawMessage `json:"or"`
+				} `json:"filter"`
+			}
+			if err := json.Unmarshal(body, &req); err != nil {
+				t.Fatalf("invalid request body %s: %v", body, err)
+			}
+			if len(req.Filter.Or) != len(tt.filters) {
+				t.Fatalf("got filters %s, want %d", body, len(tt.filters))
+			}
+			for i, filter := range req.Filter.Or {
+				if len(filter) != 2 || string(filter["property"]) != `"記事"` {
+					t.Errorf("filter %d = %s, want only property and url", i, body)
+				}
+				var condition struct {
+					Equals string `json:"equals"`
+				}
+				if err := json.Unmarshal(filter["url"], &condition); err != nil || condition.Equals != tt.filters[i] {
+					t.Errorf("filter %d = %s, want url equals %q", i, filter["url"], tt.filters[i])
+				}
+			}
+		})
+	}
+}
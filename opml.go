@@ -0,0 +1,102 @@
+package artisum
+
+import (
+	"encoding/xml"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/samber/lo"
+)
+
+type OPML struct {
+	XMLName xml.Name `xml:"opml"`
+	Version string   `xml:"version,attr"`
+	Head    OPMLHead `xml:"head"`
+	Body    OPMLBody `xml:"body"`
+}
+
+type OPMLHead struct {
+	Title       string `xml:"title,omitempty"`
+	DateCreated string `xml:"dateCreated,omitempty"`
+}
+
+type OPMLBody struct {
+	Outlines []*OPMLOutline `xml:"outline"`
+}
+
+type OPMLOutline struct {
+	Text     string         `xml:"text,attr"`
+	Title    string         `xml:"title,attr,omitempty"`
+	Type     string         `xml:"type,attr,omitempty"`
+	XMLURL   string         `xml:"xmlUrl,attr,omitempty"`
+	HTMLURL  string         `xml:"htmlUrl,attr,omitempty"`
+	Category string         `xml:"category,attr,omitempty"`
+	Outlines []*OPMLOutline `xml:"outline"`
+}
+
+// ImportOPML returns the feeds in the subscription list. Folders are flattened and their names are kept as tags.
+func ImportOPML(r io.Reader) ([]*FeedConfig, error) {
+	var opml OPML
+	if err := xml.NewDecoder(r).Decode(&opml); err != nil {
+		return nil, err
+	}
+
+	var feeds []*FeedConfig
+	var f func([]*OPMLOutline, []string)
+	f = func(outlines []*OPMLOutline, folders []string) {
+		for _, o := range outlines {
+			if o.XMLURL == "" {
+				f(o.Outlines, append(append([]string{}, folders...), o.Text))
+				continue
+			}
+
+			feed := &FeedConfig{URL: o.XMLURL}
+			if name := lo.Ternary(o.Title != "", o.Title, o.Text); name != o.XMLURL {
+				feed.Name = name
+			}
+			tags := append([]string{}, folders...)
+			for _, c := range strings.Split(o.Category, ",") {
+				if c = strings.Trim(strings.TrimSpace(c), "/"); c != "" {
+					tags = append(tags, c)
+				}
+			}
+			feed.Tags = lo.Uniq(tags)
+			feeds = append(feeds, feed)
+		}
+	}
+	f(opml.Body.Outlines, nil)
+
+	return feeds, nil
+}
+
+func ExportOPML(w io.Writer, feeds []*FeedConfig, now time.Time) error {
+	opml := &OPML{
+		Version: "2.0",
+		Head: OPMLHead{
+			Title:       "artisum subscriptions",
+			DateCreated: now.Format(time.RFC1123Z),
+		},
+	}
+	for _, f := range feeds {
+		text := lo.Ternary(f.Name != "", f.Name, f.URL)
+		opml.Body.Outlines = append(opml.Body.Outlines, &OPMLOutline{
+			Text:     text,
+			Title:    text,
+			Type:     "rss",
+			XMLURL:   f.URL,
+			Category: strings.Join(f.Tags, ","),
+		})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(opml); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,69 @@
+package artisum
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testOPML = `<?xml version="1.0" encoding="UTF-8"?>
+<opml version="2.0">
+  <head><title>subscriptions</title></head>
+  <body>
+    <outline text="Go">
+      <outline text="The Go Blog" type="rss" xmlUrl="https://go.dev/blog/feed.atom" htmlUrl="https://go.dev/blog"/>
+      <outline text="Nested">
+        <outline text="https://example.com/feed" xmlUrl="https://example.com/feed" category="/security/, go"/>
+      </outline>
+    </outline>
+    <outline text="Zenn" title="Zenn trend" type="rss" xmlUrl="https://zenn.dev/feed"/>
+  </body>
+</opml>`
+
+func TestImportOPML(t *testing.T) {
+	feeds, err := ImportOPML(strings.NewReader(testOPML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*FeedConfig{
+		{URL: "https://go.dev/blog/feed.atom", Name: "The Go Blog", Tags: []string{"Go"}},
+		{URL: "https://example.com/feed", Tags: []string{"Go", "Nested", "security", "go"}},
+		{URL: "https://zenn.dev/feed", Name: "Zenn trend", Tags: []string{}},
+	}
+	if !reflect.DeepEqual(feeds, want) {
+		for _, f := range feeds {
+			t.Logf("%+v", f)
+		}
+		t.Errorf("unexpected feeds")
+	}
+}
+
+func TestImportOPMLInvalid(t *testing.T) {
+	if _, err := ImportOPML(strings.NewReader("<opml><body>")); err == nil {
+		t.Error("no error for a broken OPML")
+	}
+}
+
+func TestExportOPMLRoundTrip(t *testing.T) {
+	feeds := []*FeedConfig{
+		{URL: "https://go.dev/blog/feed.atom", Name: "The Go Blog", Tags: []string{"golang", "release"}},
+		{URL: "https://zenn.dev/feed", Tags: []string{}},
+	}
+	var b bytes.Buffer
+	if err := ExportOPML(&b, feeds, time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), "<dateCreated>Wed, 01 May 2024 00:00:00 +0000</dateCreated>") {
+		t.Errorf("dateCreated is missing:\n%s", b.String())
+	}
+
+	imported, err := ImportOPML(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(imported, feeds) {
+		t.Errorf("got %+v %+v, want %+v %+v", imported[0], imported[1], feeds[0], feeds[1])
+	}
+}
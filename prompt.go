@@ -0,0 +1,190 @@
+package artisum
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/tmc/langchaingo/prompts"
+)
+
+const (
+	PromptSelect = "select"
+	PromptReduce = "reduce"
+	PromptFormat = "format"
+	PromptChunk  = "chunk"
+)
+
+const (
+	defaultPromptLanguage = "ja"
+	defaultPromptPersona  = "an experienced IT engineer in web and game service development"
+)
+
+var languageNames = map[string]string{
+	"ja": "Japanese",
+	"en": "English",
+	"zh": "Chinese",
+	"ko": "Korean",
+}
+
+// promptInputs are the variables given per call. The others are fixed when the templates are loaded.
+var promptInputs = map[string][]string{
+	PromptSelect: {"context"},
+	PromptReduce: {"context"},
+	PromptFormat: {"context"},
+	PromptChunk:  {"index", "total", "context"},
+}
+
+//go:embed prompts
+var defaultPromptFS embed.FS
+
+type PromptsConfig struct {
+	// Dir is searched for "<language>/<name>.tmpl" and then "<name>.tmpl" before the built-in templates.
+	Dir string `json:"dir,omitempty"`
+	// Templates sets the template file of a prompt by its name: select, reduce, format or chunk.
+	Templates map[string]string `json:"templates,omitempty"`
+	// Version labels the templates. It is prefixed to the hash of the templates in the recorded version.
+	Version  string `json:"version,omitempty"`
+	Language string `json:"language,omitempty"`
+	Persona  string `json:"persona,omitempty"`
+}
+
+// PromptSet is the prompt templates of a run with the variables shared by every prompt:
+// language, persona, fields (the summary fields as a numbered list), count and tags (a JSON array).
+type PromptSet struct {
+	templates map[string]prompts.PromptTemplate
+	version   string
+}
+
+func LoadPrompts(conf *PromptsConfig, summary *SummarySchema, count int, tags []*InterestTag) (*PromptSet, error) {
+	tagsJSON, err := json.Marshal(promptTags(tags))
+	if err != nil {
+		return nil, err
+	}
+	language := languageNames[conf.Language]
+	if language == "" {
+		language = conf.Language
+	}
+	// Partial variables have to be strings.
+	partials := map[string]any{
+		"language": language,
+		"persona":  conf.Persona,
+		"fields":   summary.promptDescription(),
+		"count":    strconv.Itoa(count),
+		"tags":     string(tagsJSON),
+	}
+
+	set := &PromptSet{
+		templates: make(map[string]prompts.PromptTemplate, len(promptInputs)),
+	}
+	hash := sha256.New()
+	names := make([]string, 0, len(promptInputs))
+	for name := range promptInputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		source, err := conf.readTemplate(name)
+		if err != nil {
+			return nil, err
+		}
+		template := prompts.PromptTemplate{
+			Template:         source,
+			InputVariables:   promptInputs[name],
+			TemplateFormat:   prompts.TemplateFormatGoTemplate,
+			PartialVariables: partials,
+		}
+		// Render once so that a broken template fails here rather than in the middle of a run.
+		if _, err := template.Format(sampleInputs(promptInputs[name])); err != nil {
+			return nil, fmt.Errorf("invalid %s prompt template: %w", name, err)
+		}
+		set.templates[name] = template
+		fmt.Fprintf(hash, "%s\n%s\n", name, source)
+	}
+
+	partialsJSON, err := json.Marshal(partials)
+	if err != nil {
+		return nil, err
+	}
+	hash.Write(partialsJSON)
+	set.version = hex.EncodeToString(hash.Sum(nil))[:12]
+	if conf.Version != "" {
+		set.version = conf.Version + "-" + set.version
+	}
+	return set, nil
+}
+
+type promptTag struct {
+	Name        string `json:"name"`
+	Level       int    `json:"level"`
+	Description string `json:"description,omitempty"`
+}
+
+// promptTags leaves out the settings of tags which do not concern the LLM, such as filters.
+func promptTags(tags []*InterestTag) []promptTag {
+	result := make([]promptTag, len(tags))
+	for i, t := range tags {
+		result[i] = promptTag{Name: t.Name, Level: t.Level, Description: t.Description}
+	}
+	return result
+}
+
+func (s *PromptSet) Template(name string) prompts.PromptTemplate {
+	return s.templates[name]
+}
+
+// Version identifies the templates and variables, so that a summary can be traced back to the prompts that produced it.
+func (s *PromptSet) Version() string {
+	return s.version
+}
+
+func (c *PromptsConfig) readTemplate(name string) (string, error) {
+	if file, ok := c.Templates[name]; ok {
+		b, err := os.ReadFile(file)
+		return string(b), err
+	}
+
+	file := name + ".tmpl"
+	if c.Dir != "" {
+		for _, p := range []string{filepath.Join(c.Dir, c.Language, file), filepath.Join(c.Dir, file)} {
+			b, err := os.ReadFile(p)
+			if err == nil {
+				return string(b), nil
+			}
+			if !os.IsNotExist(err) {
+				return "", err
+			}
+		}
+	}
+
+	b, err := fs.ReadFile(defaultPromptFS, path.Join("prompts", c.Language, file))
+	if err != nil {
+		b, err = fs.ReadFile(defaultPromptFS, path.Join("prompts", file))
+	}
+	return string(b), err
+}
+
+func sampleInputs(names []string) map[string]any {
+	values := make(map[string]any, len(names))
+	for _, name := range names {
+		values[name] = ""
+	}
+	return values
+}
+
+func (c *PromptsConfig) setDefaults() {
+	if c.Language == "" {
+		c.Language = defaultPromptLanguage
+	}
+	if c.Persona == "" {
+		c.Persona = defaultPromptPersona
+	}
+}
@@ -0,0 +1,137 @@
+package artisum
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writePromptFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func loadTestPrompts(t *testing.T, conf *PromptsConfig, count int) (*PromptSet, error) {
+	t.Helper()
+	conf.setDefaults()
+	summary := &SummarySchema{}
+	summary.setDefaults(conf.Language)
+	return LoadPrompts(conf, summary, count, testTags())
+}
+
+func TestLoadPromptsOverrides(t *testing.T) {
+	dir := t.TempDir()
+	writePromptFiles(t, dir, map[string]string{
+		"select.tmpl":    "dir select {{.count}}",
+		"reduce.tmpl":    "dir reduce",
+		"en/reduce.tmpl": "english reduce in {{.language}}",
+		"format.tmpl":    "dir format",
+		"custom.tmpl":    "custom format {{.context}}",
+	})
+	conf := &PromptsConfig{
+		Dir:       dir,
+		Language:  "en",
+		Templates: map[string]string{PromptFormat: filepath.Join(dir, "custom.tmpl")},
+	}
+	set, err := loadTestPrompts(t, conf, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	embedded, err := defaultPromptFS.ReadFile("prompts/chunk.tmpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		want string
+	}{
+		{PromptFormat, "custom format article"},
+		{PromptReduce, "english reduce in English"},
+		{PromptSelect, "dir select 3"},
+	}
+	for _, tt := range tests {
+		got, err := set.Template(tt.name).Format(map[string]any{"context": "article"})
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+	if got := set.Template(PromptChunk).Template; got != string(embedded) {
+		t.Errorf("chunk = %q, want the built-in template", got)
+	}
+}
+
+func TestLoadPromptsBrokenTemplate(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name   string
+		source string
+	}{
+		{"syntax", "{{.context"},
+		// Fails only when it is rendered.
+		{"execution", "{{index .context 5}}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name+".tmpl")
+			writePromptFiles(t, dir, map[string]string{tt.name + ".tmpl": tt.source})
+			_, err := loadTestPrompts(t, &PromptsConfig{Templates: map[string]string{PromptSelect: path}}, 3)
+			if err == nil || !strings.Contains(err.Error(), "invalid select prompt template") {
+				t.Errorf("err = %v", err)
+			}
+		})
+	}
+
+	if _, err := loadTestPrompts(t, &PromptsConfig{Templates: map[string]string{PromptSelect: filepath.Join(dir, "missing.tmpl")}}, 3); err == nil {
+		t.Error("a missing template file is loaded")
+	}
+}
+
+func TestLoadPromptsVersion(t *testing.T) {
+	dir := t.TempDir()
+	writePromptFiles(t, dir, map[string]string{"select.tmpl": "select {{.count}}"})
+	version := func(conf *PromptsConfig, count int) string {
+		t.Helper()
+		set, err := loadTestPrompts(t, conf, count)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return set.Version()
+	}
+
+	base := version(&PromptsConfig{Dir: dir, Version: "v2"}, 3)
+	if !strings.HasPrefix(base, "v2-") || len(base) != len("v2-")+12 {
+		t.Errorf("version = %q, want the label and the hash", base)
+	}
+	if got := version(&PromptsConfig{Dir: dir, Version: "v2"}, 3); got != base {
+		t.Errorf("version = %q of the same prompts, want %q", got, base)
+	}
+	if got := version(&PromptsConfig{Dir: dir}, 3); got != strings.TrimPrefix(base, "v2-") {
+		t.Errorf("version = %q without a label, want the hash of %q", got, base)
+	}
+
+	changed := map[string]string{
+		"count":   version(&PromptsConfig{Dir: dir, Version: "v2"}, 5),
+		"persona": version(&PromptsConfig{Dir: dir, Version: "v2", Persona: "a researcher"}, 3),
+	}
+	writePromptFiles(t, dir, map[string]string{"select.tmpl": "select {{.count}} articles"})
+	changed["template"] = version(&PromptsConfig{Dir: dir, Version: "v2"}, 3)
+	for what, got := range changed {
+		if got == base || !strings.HasPrefix(got, "v2-") {
+			t.Errorf("version = %q after the %s changed, base %q", got, what, base)
+		}
+	}
+}
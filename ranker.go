@@ -0,0 +1,244 @@
+package artisum
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/tmc/langchaingo/embeddings"
+	"github.com/tmc/langchaingo/llms/googleai"
+	"github.com/tmc/langchaingo/llms/ollama"
+	"github.com/tmc/langchaingo/llms/openai"
+)
+
+// rankTextLength is the number of characters of an article which are embedded. The beginning is enough to tell the topic.
+const rankTextLength = 2000
+
+type EmbeddingConfig struct {
+	// Provider is openai, ollama or googleai. Ranking is disabled when it is empty.
+	Provider  string `json:"provider,omitempty"`
+	Model     string `json:"model,omitempty"`
+	BaseURL   string `json:"baseUrl,omitempty"`
+	APIKeyEnv string `json:"apiKeyEnv,omitempty"`
+	// TopK is the number of articles sent to the LLM. All ranked articles are sent when it is 0.
+	TopK int `json:"topK,omitempty"`
+	// MinScore drops the articles scored lower, even if they are in the top K.
+	MinScore float64 `json:"minScore,omitempty"`
+}
+
+type EmbedderProvider func(ctx context.Context, conf *EmbeddingConfig) (embeddings.EmbedderClient, error)
+
+var embedderProviders = map[string]EmbedderProvider{
+	"openai":   newOpenAIEmbedder,
+	"ollama":   newOllamaEmbedder,
+	"googleai": newGoogleAIEmbedder,
+}
+
+func RegisterEmbedderProvider(name string, provider EmbedderProvider) {
+	embedderProviders[name] = provider
+}
+
+// Ranker scores the articles by the cosine similarity to the profile of each tag, weighted by the level of the tag
+// and the weight of the feed, and passes only the best ones to the LLM.
+// The profile of a tag is the average embedding of its name with the description and its examples.
+type Ranker struct {
+	embedder embeddings.Embedder
+	tags     []*InterestTag
+	weights  map[string]float64
+	topK     int
+	minScore float64
+	profiles [][]float32
+}
+
+// NewRanker returns nil when no embedding provider is configured.
+func NewRanker(ctx context.Context, conf *EmbeddingConfig, tags []*InterestTag, feeds []*FeedConfig) (*Ranker, error) {
+	if conf == nil || conf.Provider == "" {
+		return nil, nil
+	}
+	provider, ok := embedderProviders[conf.Provider]
+	if !ok {
+		return nil, fmt.Errorf("unknown embedding provider: %q", conf.Provider)
+	}
+	client, err := provider(ctx, conf)
+	if err != nil {
+		return nil, err
+	}
+	embedder, err := embeddings.NewEmbedder(client)
+	if err != nil {
+		return nil, err
+	}
+	weights := make(map[string]float64, len(feeds))
+	for _, f := range feeds {
+		weights[f.URL] = f.weight()
+	}
+	return &Ranker{
+		embedder: embedder,
+		tags:     tags,
+		weights:  weights,
+		topK:     conf.TopK,
+		minScore: conf.MinScore,
+	}, nil
+}
+
+// Rank returns the top articles and the score of every article by URL.
+func (r *Ranker) Rank(ctx context.Context, articlesMap map[string][]*Article) (map[string][]*Article, map[string]float64, error) {
+	if err := r.embedProfiles(ctx); err != nil {
+		return nil, nil, err
+	}
+
+	type ranked struct {
+		feedURL string
+		article *Article
+		score   float64
+	}
+	var (
+		candidates []*ranked
+		texts      []string
+	)
+	for feedURL, articles := range articlesMap {
+		for _, article := range articles {
+			candidates = append(candidates, &ranked{feedURL: feedURL, article: article})
+			texts = append(texts, rankText(article))
+		}
+	}
+	if len(candidates) == 0 {
+		return articlesMap, nil, nil
+	}
+	vectors, err := r.embedder.EmbedDocuments(ctx, texts)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to embed articles: %w", err)
+	}
+	if len(vectors) != len(candidates) {
+		return nil, nil, fmt.Errorf("got %d embeddings for %d articles", len(vectors), len(candidates))
+	}
+
+	maxLevel := 1
+	for _, tag := range r.tags {
+		maxLevel = max(maxLevel, tag.Level)
+	}
+	scores := make(map[string]float64, len(candidates))
+	for i, c := range candidates {
+		for j, tag := range r.tags {
+			score := cosineSimilarity(vectors[i], r.profiles[j]) * float64(max(tag.Level, 1)) / float64(maxLevel)
+			c.score = max(c.score, score)
+		}
+		c.score *= r.weight(c.feedURL)
+		scores[c.article.Url] = c.score
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].score > candidates[j].score })
+	ranks := make(map[string][]*Article, len(articlesMap))
+	kept := 0
+	for _, c := range candidates {
+		if (r.topK > 0 && kept >= r.topK) || c.score < r.minScore {
+			slog.Debug("rank out article", slog.String("url", c.article.Url), slog.Float64("score", c.score))
+			continue
+		}
+		ranks[c.feedURL] = append(ranks[c.feedURL], c.article)
+		kept++
+	}
+	slog.Info("ranked articles", slog.Int("articles", len(candidates)), slog.Int("kept", kept))
+	return ranks, scores, nil
+}
+
+// weight is the weight of the feed, 1 for a feed which is not configured.
+func (r *Ranker) weight(feedURL string) float64 {
+	if w, ok := r.weights[feedURL]; ok {
+		return w
+	}
+	return 1
+}
+
+func (r *Ranker) embedProfiles(ctx context.Context) error {
+	if r.profiles != nil {
+		return nil
+	}
+	profiles := make([][]float32, len(r.tags))
+	for i, tag := range r.tags {
+		texts := []string{strings.TrimSpace(tag.Name + "\n" + tag.Description)}
+		texts = append(texts, tag.Examples...)
+		vectors, err := r.embedder.EmbedDocuments(ctx, texts)
+		if err != nil {
+			return fmt.Errorf("failed to embed tag %s: %w", tag.Name, err)
+		}
+		weights := make([]int, len(vectors))
+		for j := range weights {
+			weights[j] = 1
+		}
+		profiles[i], err = embeddings.CombineVectors(vectors, weights)
+		if err != nil {
+			return fmt.Errorf("failed to embed tag %s: %w", tag.Name, err)
+		}
+	}
+	r.profiles = profiles
+	return nil
+}
+
+func rankText(article *Article) string {
+	text := []rune(filterText(article))
+	if len(text) > rankTextLength {
+		text = text[:rankTextLength]
+	}
+	return string(text)
+}
+
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(normA*normB)
+}
+
+func (c *EmbeddingConfig) apiKey() string {
+	if c.APIKeyEnv == "" {
+		return ""
+	}
+	return os.Getenv(c.APIKeyEnv)
+}
+
+func newOpenAIEmbedder(_ context.Context, conf *EmbeddingConfig) (embeddings.EmbedderClient, error) {
+	var opts []openai.Option
+	if conf.Model != "" {
+		opts = append(opts, openai.WithModel(conf.Model), openai.WithEmbeddingModel(conf.Model))
+	}
+	if conf.BaseURL != "" {
+		opts = append(opts, openai.WithBaseURL(conf.BaseURL))
+	}
+	if key := conf.apiKey(); key != "" {
+		opts = append(opts, openai.WithToken(key))
+	}
+	return openai.New(opts...)
+}
+
+func newOllamaEmbedder(_ context.Context, conf *EmbeddingConfig) (embeddings.EmbedderClient, error) {
+	opts := []ollama.Option{ollama.WithModel(conf.Model)}
+	if conf.BaseURL != "" {
+		opts = append(opts, ollama.WithServerURL(conf.BaseURL))
+	}
+	return ollama.New(opts...)
+}
+
+func newGoogleAIEmbedder(ctx context.Context, conf *EmbeddingConfig) (embeddings.EmbedderClient, error) {
+	key := conf.apiKey()
+	if key == "" {
+		key = os.Getenv("GOOGLE_API_KEY")
+	}
+	opts := []googleai.Option{googleai.WithAPIKey(key)}
+	if conf.Model != "" {
+		opts = append(opts, googleai.WithDefaultEmbeddingModel(conf.Model))
+	}
+	return googleai.New(ctx, opts...)
+}
@@ -0,0 +1,92 @@
+package artisum
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/tmc/langchaingo/embeddings"
+)
+
+// keywordEmbedder embeds a text as the counts of the keywords in it.
+type keywordEmbedder []string
+
+func (e keywordEmbedder) CreateEmbedding(_ context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vectors[i] = make([]float32, len(e))
+		for j, keyword := range e {
+			vectors[i][j] = float32(strings.Count(strings.ToLower(text), keyword))
+		}
+	}
+	return vectors, nil
+}
+
+func newTestRanker(t *testing.T, topK int, feeds []*FeedConfig) *Ranker {
+	t.Helper()
+	embedder, err := embeddings.NewEmbedder(keywordEmbedder{"go", "rust", "react"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	weights := make(map[string]float64)
+	for _, f := range feeds {
+		weights[f.URL] = f.weight()
+	}
+	return &Ranker{
+		embedder: embedder,
+		tags:     []*InterestTag{{Name: "go", Level: 3}, {Name: "rust", Level: 1}},
+		weights:  weights,
+		topK:     topK,
+	}
+}
+
+func TestRankerRank(t *testing.T) {
+	articlesMap := map[string][]*Article{
+		"https://a.example.com/feed": {
+			{Title: "go go", Url: "https://a.example.com/go"},
+			{Title: "rust", Url: "https://a.example.com/rust"},
+			{Title: "react", Url: "https://a.example.com/react"},
+		},
+	}
+	ranker := newTestRanker(t, 2, nil)
+	ranked, scores, err := ranker.Rank(context.Background(), articlesMap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := ranked["https://a.example.com/feed"]
+	if len(got) != 2 || got[0].Url != "https://a.example.com/go" || got[1].Url != "https://a.example.com/rust" {
+		t.Errorf("unexpected ranking %v", got)
+	}
+	if s := scores["https://a.example.com/go"]; math.Abs(s-1) > 1e-6 {
+		t.Errorf("score of go = %f, want 1", s)
+	}
+	if s := scores["https://a.example.com/rust"]; math.Abs(s-1.0/3) > 1e-6 {
+		t.Errorf("score of rust = %f, want 1/3 by the level", s)
+	}
+	if s := scores["https://a.example.com/react"]; s != 0 {
+		t.Errorf("score of react = %f, want 0", s)
+	}
+}
+
+func TestRankerFeedWeight(t *testing.T) {
+	feeds := []*FeedConfig{
+		{URL: "https://a.example.com/feed", Weight: 0.2},
+		{URL: "https://b.example.com/feed", Weight: 2},
+	}
+	articlesMap := map[string][]*Article{
+		"https://a.example.com/feed": {{Title: "go", Url: "https://a.example.com/go"}},
+		"https://b.example.com/feed": {{Title: "rust", Url: "https://b.example.com/rust"}},
+	}
+	ranker := newTestRanker(t, 1, feeds)
+	ranked, scores, err := ranker.Rank(context.Background(), articlesMap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ranked["https://b.example.com/feed"]) != 1 || len(ranked["https://a.example.com/feed"]) != 0 {
+		t.Errorf("the weight of the feed is ignored: %v", ranked)
+	}
+	if s := scores["https://a.example.com/go"]; math.Abs(s-0.2) > 1e-6 {
+		t.Errorf("score of go = %f, want 0.2", s)
+	}
+}
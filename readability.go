@@ -0,0 +1,560 @@
+package artisum
+
+import (
+	"encoding/json"
+	"math"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// PageContent is the main content of a web page with its metadata.
+type PageContent struct {
+	Title       string
+	Author      string
+	PublishedAt time.Time
+	LeadImage   string
+	// Text keeps the structure of the content: headings start with "#", list items with "-" and code is fenced with "```".
+	Text string
+}
+
+const minContentLength = 200
+
+// String renders the content with its metadata as plain text for prompts.
+func (c *PageContent) String() string {
+	var b strings.Builder
+	if c.Title != "" {
+		b.WriteString("Title: " + c.Title + "\n")
+	}
+	if c.Author != "" {
+		b.WriteString("Author: " + c.Author + "\n")
+	}
+	if !c.PublishedAt.IsZero() {
+		b.WriteString("Published: " + c.PublishedAt.Format(time.DateOnly) + "\n")
+	}
+	if b.Len() > 0 {
+		b.WriteString("\n")
+	}
+	b.WriteString(c.Text)
+	return b.String()
+}
+
+// The class and ID regexps match whole tokens of the attributes, separated by spaces, hyphens or underscores,
+// so that e.g. "ads" does not match "downloads" nor "meta" match "metadata".
+var (
+	unlikelyCandidateRegexp = classTokenRegexp(`ads?|advert|advertisement|banner|breadcrumbs?|combx|comments?|community|cookies?|consent|disqus|footer|gdpr|header|menu|modal|nav|navbar|navigation|newsletter|pager|pagination|popup|promo|related|remark|replies|rss|share|sharing|shoutbox|sidebar|skyscraper|social|sponsor|sponsored|subscribe|tag-?cloud|toolbar|widgets?`)
+	maybeCandidateRegexp    = classTokenRegexp(`article|body|column|content|main|shadow`)
+	positiveRegexp          = classTokenRegexp(`article|body|content|entry|hentry|h-entry|main|page|post|text|blog|story`)
+	negativeRegexp          = classTokenRegexp(`ad|hidden|hid|banner|combx|comments?|com|contact|foot|footer|footnotes?|gdpr|masthead|media|meta|outbrain|promo|related|scroll|share|shoutbox|sidebar|skyscraper|sponsor|shopping|tags|tool|tools|widgets?`)
+)
+
+func classTokenRegexp(words string) *regexp.Regexp {
+	return regexp.MustCompile(`(?i)(?:^|[\s_-])(?:` + words + `)(?:$|[\s_-])`)
+}
+
+var removedTags = map[string]bool{
+	"script": true, "style": true, "noscript": true, "template": true, "iframe": true, "object": true, "embed": true,
+	"form": true, "button": true, "input": true, "select": true, "textarea": true, "svg": true, "canvas": true,
+	"nav": true, "footer": true, "aside": true, "dialog": true, "menu": true,
+}
+
+var blockTags = map[string]bool{
+	"address": true, "article": true, "blockquote": true, "dd": true, "details": true, "div": true, "dl": true,
+	"dt": true, "figcaption": true, "figure": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true,
+	"h6": true, "header": true, "hr": true, "li": true, "main": true, "ol": true, "p": true, "pre": true,
+	"section": true, "summary": true, "table": true, "tr": true, "ul": true,
+}
+
+// ExtractPageContent picks the main content of the document, dropping navigation, footers,
+// banners and comments, and reads the page metadata.
+func ExtractPageContent(doc *html.Node, pageURL *url.URL) *PageContent {
+	content := &PageContent{}
+	readMetadata(doc, pageURL, content)
+
+	body := findFirst(doc, "body")
+	if body == nil {
+		body = doc
+	}
+	removeUnlikelyNodes(body)
+
+	main := findMainContent(body)
+	text := renderText(main)
+	if main != body && len([]rune(text)) < minContentLength {
+		text = renderText(body)
+	}
+	content.Text = text
+
+	if content.Title == "" {
+		if h1 := findFirst(body, "h1"); h1 != nil {
+			content.Title = collapseSpaces(textContent(h1))
+		}
+	}
+	return content
+}
+
+func removeUnlikelyNodes(n *html.Node) {
+	var next *html.Node
+	for c := n.FirstChild; c != nil; c = next {
+		next = c.NextSibling
+		if c.Type == html.CommentNode || (c.Type == html.ElementNode && isUnlikely(c)) {
+			n.RemoveChild(c)
+			continue
+		}
+		removeUnlikelyNodes(c)
+	}
+}
+
+func isUnlikely(n *html.Node) bool {
+	if removedTags[n.Data] {
+		return true
+	}
+	if _, hidden := attrValue(n, "hidden"); hidden || attr(n, "aria-hidden") == "true" {
+		return true
+	}
+	style := strings.ReplaceAll(strings.ToLower(attr(n, "style")), " ", "")
+	if strings.Contains(style, "display:none") || strings.Contains(style, "visibility:hidden") {
+		return true
+	}
+	if n.Data == "body" || n.Data == "article" || n.Data == "main" || n.Data == "a" || hasAncestor(n, "table") || hasAncestor(n, "code") {
+		return false
+	}
+	switch attr(n, "role") {
+	case "navigation", "banner", "contentinfo", "complementary", "dialog", "alert", "menu", "menubar":
+		return true
+	}
+	classAndID := attr(n, "class") + " " + attr(n, "id")
+	return unlikelyCandidateRegexp.MatchString(classAndID) && !maybeCandidateRegexp.MatchString(classAndID)
+}
+
+// findMainContent prefers a single <article> or <main> element, and falls back to
+// readability-style scoring of the paragraphs.
+func findMainContent(body *html.Node) *html.Node {
+	for _, tag := range []string{"article", "main"} {
+		nodes := findAll(body, tag)
+		if len(nodes) == 1 && len([]rune(collapseSpaces(textContent(nodes[0])))) >= minContentLength && linkDensity(nodes[0]) < 0.5 {
+			return nodes[0]
+		}
+	}
+
+	scores := make(map[*html.Node]float64)
+	for _, p := range findAll(body, "p", "pre", "td", "blockquote", "section") {
+		text := collapseSpaces(textContent(p))
+		length := len([]rune(text))
+		if length < 25 {
+			continue
+		}
+
+		score := 1 + float64(strings.Count(text, ",")+strings.Count(text, "、")+strings.Count(text, "。"))
+		score += math.Min(float64(length)/100, 3)
+
+		parent := p.Parent
+		for level := 0; parent != nil && parent.Type == html.ElementNode && level < 3; level++ {
+			if _, ok := scores[parent]; !ok {
+				scores[parent] = initialScore(parent)
+			}
+			switch level {
+			case 0:
+				scores[parent] += score
+			case 1:
+				scores[parent] += score / 2
+			default:
+				scores[parent] += score / float64(level*3)
+			}
+			parent = parent.Parent
+		}
+	}
+
+	var (
+		top      *html.Node
+		topScore float64
+	)
+	for n, score := range scores {
+		score *= 1 - linkDensity(n)
+		if top == nil || score > topScore {
+			top, topScore = n, score
+		}
+	}
+	if top == nil {
+		return body
+	}
+
+	// climb up while the parent is mostly the same content, so that the headings next to the paragraphs are kept.
+	for top.Parent != nil && top.Parent != body && top.Parent.Type == html.ElementNode {
+		if len([]rune(textContent(top)))*10 < len([]rune(textContent(top.Parent)))*8 {
+			break
+		}
+		top = top.Parent
+	}
+	return top
+}
+
+func initialScore(n *html.Node) float64 {
+	var score float64
+	switch n.Data {
+	case "article", "main":
+		score = 10
+	case "div":
+		score = 5
+	case "pre", "td", "blockquote", "section":
+		score = 3
+	case "address", "ol", "ul", "dl", "dd", "dt", "li", "form":
+		score = -3
+	case "h1", "h2", "h3", "h4", "h5", "h6", "th":
+		score = -5
+	}
+
+	for _, v := range []string{attr(n, "class"), attr(n, "id")} {
+		if v == "" {
+			continue
+		}
+		if negativeRegexp.MatchString(v) {
+			score -= 25
+		}
+		if positiveRegexp.MatchString(v) {
+			score += 25
+		}
+	}
+	return score
+}
+
+func linkDensity(n *html.Node) float64 {
+	length := len([]rune(textContent(n)))
+	if length == 0 {
+		return 0
+	}
+	var linkLength int
+	for _, a := range findAll(n, "a") {
+		linkLength += len([]rune(textContent(a)))
+	}
+	return float64(linkLength) / float64(length)
+}
+
+type textRenderer struct {
+	buf        strings.Builder
+	line       strings.Builder
+	prefix     string
+	pendingGap bool
+}
+
+func renderText(n *html.Node) string {
+	r := &textRenderer{}
+	r.render(n, 0)
+	r.flushLine()
+	return strings.TrimSpace(r.buf.String())
+}
+
+func (r *textRenderer) render(n *html.Node, listDepth int) {
+	switch n.Type {
+	case html.TextNode:
+		r.writeInline(n.Data)
+		return
+	case html.ElementNode:
+	default:
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			r.render(c, listDepth)
+		}
+		return
+	}
+
+	switch n.Data {
+	case "br":
+		r.flushLine()
+		return
+	case "hr":
+		r.flushLine()
+		r.pendingGap = true
+		return
+	case "img":
+		return
+	case "pre":
+		r.flushLine()
+		r.gap()
+		r.buf.WriteString("```\n")
+		r.buf.WriteString(strings.Trim(textContent(n), "\n"))
+		r.buf.WriteString("\n```\n")
+		r.pendingGap = true
+		return
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		r.flushLine()
+		r.gap()
+		r.prefix = strings.Repeat("#", int(n.Data[1]-'0')) + " "
+		r.renderChildren(n, listDepth)
+		r.flushLine()
+		r.pendingGap = true
+		return
+	case "li":
+		r.flushLine()
+		marker := "- "
+		if n.Parent != nil && n.Parent.Data == "ol" {
+			marker = "1. "
+		}
+		r.prefix = strings.Repeat("  ", max(listDepth-1, 0)) + marker
+		r.renderChildren(n, listDepth)
+		r.flushLine()
+		return
+	case "ul", "ol":
+		r.flushLine()
+		if listDepth == 0 {
+			r.gap()
+		}
+		r.renderChildren(n, listDepth+1)
+		if listDepth == 0 {
+			r.pendingGap = true
+		}
+		return
+	case "blockquote":
+		r.flushLine()
+		for _, line := range strings.Split(renderText(n), "\n") {
+			r.buf.WriteString("> " + line + "\n")
+		}
+		return
+	case "td", "th":
+		if n.PrevSibling != nil {
+			r.writeInline(" | ")
+		}
+		r.renderChildren(n, listDepth)
+		return
+	case "code":
+		r.writeInline("`")
+		r.renderChildren(n, listDepth)
+		r.writeInline("`")
+		return
+	}
+
+	if blockTags[n.Data] {
+		r.flushLine()
+		if n.Data == "p" {
+			r.gap()
+		}
+		r.renderChildren(n, listDepth)
+		r.flushLine()
+		return
+	}
+	r.renderChildren(n, listDepth)
+}
+
+func (r *textRenderer) renderChildren(n *html.Node, listDepth int) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		r.render(c, listDepth)
+	}
+}
+
+// writeInline collapses whitespace like a browser does, so that words separated in the source
+// stay separated while Japanese text without spaces is not split.
+func (r *textRenderer) writeInline(s string) {
+	collapsed := whitespaceRegexp.ReplaceAllString(s, " ")
+	if collapsed == " " || collapsed == "" {
+		if r.line.Len() > 0 && !strings.HasSuffix(r.line.String(), " ") {
+			r.line.WriteString(" ")
+		}
+		return
+	}
+	if r.line.Len() == 0 || strings.HasSuffix(r.line.String(), " ") {
+		collapsed = strings.TrimLeft(collapsed, " ")
+	}
+	r.line.WriteString(collapsed)
+}
+
+func (r *textRenderer) flushLine() {
+	line := strings.TrimSpace(r.line.String())
+	r.line.Reset()
+	if line == "" {
+		return
+	}
+	if r.pendingGap {
+		r.gap()
+	}
+	r.buf.WriteString(r.prefix + line + "\n")
+	r.prefix = ""
+}
+
+func (r *textRenderer) gap() {
+	r.pendingGap = false
+	if r.buf.Len() > 0 && !strings.HasSuffix(r.buf.String(), "\n\n") {
+		r.buf.WriteString("\n")
+	}
+}
+
+var whitespaceRegexp = regexp.MustCompile(`\s+`)
+
+func collapseSpaces(s string) string {
+	return strings.TrimSpace(whitespaceRegexp.ReplaceAllString(s, " "))
+}
+
+func readMetadata(doc *html.Node, pageURL *url.URL, content *PageContent) {
+	meta := make(map[string]string)
+	for _, m := range findAll(doc, "meta") {
+		key := strings.ToLower(firstNonEmpty(attr(m, "property"), attr(m, "name"), attr(m, "itemprop")))
+		if value := strings.TrimSpace(attr(m, "content")); key != "" && value != "" {
+			if _, ok := meta[key]; !ok {
+				meta[key] = value
+			}
+		}
+	}
+	for _, script := range findAll(doc, "script") {
+		if attr(script, "type") == "application/ld+json" {
+			readJSONLD(textContent(script), meta)
+		}
+	}
+
+	content.Title = firstNonEmpty(meta["og:title"], meta["twitter:title"], meta["ld:headline"])
+	if content.Title == "" {
+		if title := findFirst(doc, "title"); title != nil {
+			content.Title = collapseSpaces(textContent(title))
+		}
+	}
+	content.Author = firstNonEmpty(meta["author"], meta["article:author"], meta["ld:author"], meta["twitter:creator"])
+
+	published := firstNonEmpty(meta["article:published_time"], meta["datepublished"], meta["ld:datepublished"], meta["date"], meta["pubdate"])
+	if published == "" {
+		if t := findFirst(doc, "time"); t != nil {
+			published = attr(t, "datetime")
+		}
+	}
+	content.PublishedAt = parseDatetime(published)
+
+	content.LeadImage = firstNonEmpty(meta["og:image"], meta["twitter:image"], meta["ld:image"])
+	if content.LeadImage != "" && pageURL != nil {
+		if u, err := pageURL.Parse(content.LeadImage); err == nil {
+			content.LeadImage = u.String()
+		}
+	}
+}
+
+func readJSONLD(raw string, meta map[string]string) {
+	var v any
+	if err := json.Unmarshal([]byte(raw), &v); err != nil {
+		return
+	}
+
+	var objects []map[string]any
+	var collect func(any)
+	collect = func(v any) {
+		switch v := v.(type) {
+		case []any:
+			for _, e := range v {
+				collect(e)
+			}
+		case map[string]any:
+			objects = append(objects, v)
+			if graph, ok := v["@graph"]; ok {
+				collect(graph)
+			}
+		}
+	}
+	collect(v)
+
+	for _, o := range objects {
+		for _, key := range []string{"headline", "author", "datePublished", "image"} {
+			metaKey := "ld:" + strings.ToLower(key)
+			if _, ok := meta[metaKey]; ok {
+				continue
+			}
+			if value := jsonLDString(o[key]); value != "" {
+				meta[metaKey] = value
+			}
+		}
+	}
+}
+
+func jsonLDString(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []any:
+		if len(v) > 0 {
+			return jsonLDString(v[0])
+		}
+	case map[string]any:
+		if name, ok := v["name"].(string); ok {
+			return name
+		}
+		if u, ok := v["url"].(string); ok {
+			return u
+		}
+	}
+	return ""
+}
+
+func parseDatetime(s string) time.Time {
+	s = strings.TrimSpace(s)
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05Z0700", "2006-01-02T15:04:05", "2006-01-02 15:04:05", time.DateOnly, time.RFC1123Z, time.RFC1123} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func textContent(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var b strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		b.WriteString(textContent(c))
+	}
+	return b.String()
+}
+
+func findFirst(n *html.Node, tag string) *html.Node {
+	if n.Type == html.ElementNode && n.Data == tag {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findFirst(c, tag); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func findAll(n *html.Node, tags ...string) []*html.Node {
+	var nodes []*html.Node
+	var f func(*html.Node)
+	f = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.ElementNode {
+				for _, tag := range tags {
+					if c.Data == tag {
+						nodes = append(nodes, c)
+						break
+					}
+				}
+			}
+			f(c)
+		}
+	}
+	f(n)
+	return nodes
+}
+
+func hasAncestor(n *html.Node, tag string) bool {
+	for p := n.Parent; p != nil; p = p.Parent {
+		if p.Type == html.ElementNode && p.Data == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func attrValue(n *html.Node, key string) (string, bool) {
+	for _, a := range n.Attr {
+		if strings.EqualFold(a.Key, key) {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
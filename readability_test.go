@@ -0,0 +1,90 @@
+package artisum
+
+import (
+	"bytes"
+	"flag"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden files")
+
+// TestExtractPageContentGolden extracts the pages in testdata/readability and compares the result with the .golden files.
+// Run the test with -update after checking the differences to rewrite them.
+func TestExtractPageContentGolden(t *testing.T) {
+	pages, err := filepath.Glob("testdata/readability/*.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) == 0 {
+		t.Fatal("no test pages")
+	}
+	pageURL, _ := url.Parse("https://example.com/posts/1")
+	for _, page := range pages {
+		t.Run(filepath.Base(page), func(t *testing.T) {
+			b, err := os.ReadFile(page)
+			if err != nil {
+				t.Fatal(err)
+			}
+			doc, err := html.Parse(bytes.NewReader(b))
+			if err != nil {
+				t.Fatal(err)
+			}
+			content := ExtractPageContent(doc, pageURL)
+			got := content.String() + "\n"
+			if content.LeadImage != "" {
+				got = "Image: " + content.LeadImage + "\n" + got
+			}
+
+			golden := strings.TrimSuffix(page, ".html") + ".golden"
+			if *updateGolden {
+				if err := os.WriteFile(golden, []byte(got), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != string(want) {
+				t.Errorf("got\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestClassTokenRegexps(t *testing.T) {
+	tests := []struct {
+		classAndID string
+		unlikely   bool
+		negative   bool
+	}{
+		{"ad-slot", true, true},
+		{"top-ads", true, false},
+		{"site-header", true, false},
+		{"comments-area", true, true},
+		{"post_meta", false, true},
+		{"head-wrapper", false, false},
+		{"wp-block-file downloads", false, false},
+		{"metadata-table", false, false},
+		{"loads", false, false},
+		{"media-gallery", false, true},
+		{"post-tags", false, true},
+		{"tagsinput", false, false},
+		{"navbar", true, false},
+		{"canvas", false, false},
+	}
+	for _, tt := range tests {
+		if got := unlikelyCandidateRegexp.MatchString(tt.classAndID); got != tt.unlikely {
+			t.Errorf("unlikely(%q) = %v, want %v", tt.classAndID, got, tt.unlikely)
+		}
+		if got := negativeRegexp.MatchString(tt.classAndID); got != tt.negative {
+			t.Errorf("negative(%q) = %v, want %v", tt.classAndID, got, tt.negative)
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package artisum
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+)
+
+type ArticleStatus string
+
+const (
+	ArticleStatusPending   ArticleStatus = "pending"
+	ArticleStatusFormatted ArticleStatus = "formatted"
+	ArticleStatusSaved     ArticleStatus = "saved"
+	ArticleStatusSkipped   ArticleStatus = "skipped"
+)
+
+const (
+	StageCheck  = "check"
+	StageFormat = "format"
+	StageSave   = "save"
+)
+
+// Run is the persisted state of one Summary execution. Each finished step is recorded
+// so that a failed run can be resumed without fetching, extracting or saving twice.
+type Run struct {
+	ID           string                `json:"id"`
+	StartedAt    time.Time             `json:"startedAt"`
+	CompletedAt  *time.Time            `json:"completedAt,omitempty"`
+	FeedArticles map[string][]*Article `json:"feedArticles,omitempty"`
+	FeedFailures []*FeedFailure        `json:"feedFailures,omitempty"`
+	// FeedValidators are committed to the feed states when the run is completed.
+	FeedValidators map[string]*FeedValidators `json:"feedValidators,omitempty"`
+	Extracted      bool                       `json:"extracted"`
+	Articles       []*RunArticle              `json:"articles,omitempty"`
+	// Rejected are the candidates the LLM did not select, kept to explain the selection.
+	Rejected []*RejectedArticle `json:"rejected,omitempty"`
+	// Flushes are the results of delivering the digests, by sink name.
+	Flushes map[string]*SinkStatus `json:"flushes,omitempty"`
+}
+
+type RunArticle struct {
+	Article  *InterestArticle `json:"article"`
+	Status   ArticleStatus    `json:"status"`
+	Contents []*FormatContent `json:"contents,omitempty"`
+	// PromptVersion is recorded with the contents because a resumed run may load different prompts.
+	PromptVersion string `json:"promptVersion,omitempty"`
+	Stage         string `json:"stage,omitempty"`
+	Error         string `json:"error,omitempty"`
+	// Sinks are the results of saving the article, by sink name.
+	Sinks map[string]*SinkStatus `json:"sinks,omitempty"`
+}
+
+// SinkStatus is the result of saving an article to a sink or of flushing a sink.
+type SinkStatus struct {
+	Saved    bool   `json:"saved"`
+	Location string `json:"location,omitempty"`
+	Error    string `json:"error,omitempty"`
+	// Delivered are the URLs of the articles the flushes delivered, by recipient.
+	Delivered map[string][]string `json:"delivered,omitempty"`
+	// Notes are what the flushes need to resume, e.g. the Slack threads the replies go to.
+	Notes map[string]string `json:"notes,omitempty"`
+}
+
+type RunReport struct {
+	RunID     string        `json:"runId"`
+	Total     int           `json:"total"`
+	Succeeded int           `json:"succeeded"`
+	Skipped   int           `json:"skipped"`
+	Failures  []*RunFailure `json:"failures,omitempty"`
+	// FeedFailures are warnings only; they never count towards the failure threshold.
+	FeedFailures []*FeedFailure `json:"feedFailures,omitempty"`
+	// SinkFailures are the sinks which failed to save an article or to flush. URL is empty for a flush.
+	SinkFailures []*SinkFailure `json:"sinkFailures,omitempty"`
+}
+
+type SinkFailure struct {
+	Sink  string `json:"sink"`
+	URL   string `json:"url,omitempty"`
+	Error string `json:"error"`
+}
+
+type RunFailure struct {
+	Title string `json:"title"`
+	URL   string `json:"url"`
+	Stage string `json:"stage"`
+	Error string `json:"error"`
+}
+
+// NewRun starts a run. The ID has a random suffix, as runs started in the same second would share the run file otherwise.
+func NewRun(now time.Time) *Run {
+	suffix := make([]byte, 3)
+	_, _ = rand.Read(suffix)
+	return &Run{
+		ID:        now.Format("20060102-150405") + "-" + hex.EncodeToString(suffix),
+		StartedAt: now,
+	}
+}
+
+// hasFeedArticles reports whether any feed returned an article. Feeds which were not modified have no articles.
+func (r *Run) hasFeedArticles() bool {
+	for _, articles := range r.FeedArticles {
+		if len(articles) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Run) setExtracted(articles []*InterestArticle, rejected []*RejectedArticle) {
+	r.Extracted = true
+	r.Rejected = rejected
+	r.Articles = make([]*RunArticle, 0, len(articles))
+	for _, article := range articles {
+		r.Articles = append(r.Articles, &RunArticle{
+			Article: article,
+			Status:  ArticleStatusPending,
+		})
+	}
+}
+
+func (a *RunArticle) isDone() bool {
+	return a.Status == ArticleStatusSaved || a.Status == ArticleStatusSkipped
+}
+
+func (a *RunArticle) fail(stage string, err error) {
+	a.Stage = stage
+	a.Error = err.Error()
+}
+
+func (a *RunArticle) clearError() {
+	a.Stage = ""
+	a.Error = ""
+}
+
+func (a *RunArticle) setSinkStatus(name string, status *SinkStatus) {
+	if a.Sinks == nil {
+		a.Sinks = make(map[string]*SinkStatus)
+	}
+	a.Sinks[name] = status
+}
+
+func (a *RunArticle) summaryArticle() *SummaryArticle {
+	locations := make(map[string]string, len(a.Sinks))
+	for name, status := range a.Sinks {
+		if status.Saved && status.Location != "" {
+			locations[name] = status.Location
+		}
+	}
+	return &SummaryArticle{
+		Origin:        a.Article,
+		Contents:      a.Contents,
+		PromptVersion: a.PromptVersion,
+		Locations:     locations,
+	}
+}
+
+// flushStatus returns the status of flushing the sink, adding it when the sink is not flushed yet.
+func (r *Run) flushStatus(name string) *SinkStatus {
+	if r.Flushes == nil {
+		r.Flushes = make(map[string]*SinkStatus)
+	}
+	status, ok := r.Flushes[name]
+	if !ok {
+		status = &SinkStatus{}
+		r.Flushes[name] = status
+	}
+	return status
+}
+
+func (s *SinkStatus) markDelivered(recipient string, urls []string) {
+	if s.Delivered == nil {
+		s.Delivered = make(map[string][]string)
+	}
+	s.Delivered[recipient] = append(s.Delivered[recipient], urls...)
+}
+
+func (s *SinkStatus) setNotes(notes map[string]string) {
+	if s.Notes == nil {
+		s.Notes = make(map[string]string, len(notes))
+	}
+	for key, value := range notes {
+		s.Notes[key] = value
+	}
+}
+
+// savedArticles are the articles saved in the run, in the order they were selected.
+func (r *Run) savedArticles() []*SummaryArticle {
+	var articles []*SummaryArticle
+	for _, a := range r.Articles {
+		if a.Status == ArticleStatusSaved {
+			articles = append(articles, a.summaryArticle())
+		}
+	}
+	return articles
+}
+
+func (r *Run) Report() *RunReport {
+	report := &RunReport{
+		RunID:        r.ID,
+		Total:        len(r.Articles),
+		FeedFailures: r.FeedFailures,
+	}
+	for _, a := range r.Articles {
+		switch {
+		case a.Status == ArticleStatusSaved:
+			report.Succeeded++
+		case a.Status == ArticleStatusSkipped:
+			report.Skipped++
+		case a.Error != "":
+			report.Failures = append(report.Failures, &RunFailure{
+				Title: a.Article.Title,
+				URL:   a.Article.URL,
+				Stage: a.Stage,
+				Error: a.Error,
+			})
+		}
+		for _, name := range sortedKeys(a.Sinks) {
+			if status := a.Sinks[name]; status.Error != "" {
+				report.SinkFailures = append(report.SinkFailures, &SinkFailure{Sink: name, URL: a.Article.URL, Error: status.Error})
+			}
+		}
+	}
+	for _, name := range sortedKeys(r.Flushes) {
+		if status := r.Flushes[name]; status.Error != "" {
+			report.SinkFailures = append(report.SinkFailures, &SinkFailure{Sink: name, Error: status.Error})
+		}
+	}
+	return report
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (r *RunReport) FailureRate() float64 {
+	if r.Total == 0 {
+		return 0
+	}
+	return float64(len(r.Failures)) / float64(r.Total)
+}
+
+// ExceedsThreshold reports whether the ratio of failed articles is above threshold (0 to 1).
+func (r *RunReport) ExceedsThreshold(threshold float64) bool {
+	return len(r.Failures) > 0 && r.FailureRate() > threshold
+}
+
+func (r *RunReport) Log() {
+	slog.Info("run report",
+		slog.String("id", r.RunID),
+		slog.Int("total", r.Total),
+		slog.Int("succeeded", r.Succeeded),
+		slog.Int("skipped", r.Skipped),
+		slog.Int("failed", len(r.Failures)),
+		slog.Int("failedFeeds", len(r.FeedFailures)),
+		slog.Int("failedSinks", len(r.SinkFailures)),
+	)
+	for _, f := range r.FeedFailures {
+		slog.Warn("failed feed",
+			slog.String("url", f.FeedURL),
+			slog.String("error", f.Error),
+			slog.Int("consecutiveFailures", f.ConsecutiveFailures),
+		)
+	}
+	for _, f := range r.SinkFailures {
+		slog.Warn("failed sink",
+			slog.String("sink", f.Sink),
+			slog.String("url", f.URL),
+			slog.String("error", f.Error),
+		)
+	}
+	for _, f := range r.Failures {
+		slog.Warn("failed article",
+			slog.String("title", f.Title),
+			slog.String("url", f.URL),
+			slog.String("stage", f.Stage),
+			slog.String("error", f.Error),
+		)
+	}
+}
+
+type FailureThresholdError struct {
+	Report    *RunReport
+	Threshold float64
+}
+
+func (e *FailureThresholdError) Error() string {
+	return fmt.Sprintf("%d of %d articles failed, which exceeds the failure threshold %.2f", len(e.Report.Failures), e.Report.Total, e.Threshold)
+}
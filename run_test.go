@@ -0,0 +1,46 @@
+package artisum
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestNewRunID(t *testing.T) {
+	now := time.Date(2024, 5, 1, 9, 30, 0, 0, time.UTC)
+	a, b := NewRun(now), NewRun(now)
+	if !regexp.MustCompile(`^20240501-093000-[0-9a-f]{6}$`).MatchString(a.ID) {
+		t.Errorf("unexpected id %q", a.ID)
+	}
+	if a.ID == b.ID {
+		t.Errorf("runs started in the same second share the id %q", a.ID)
+	}
+}
+
+func TestRunReport(t *testing.T) {
+	run := NewRun(time.Now())
+	run.setExtracted([]*InterestArticle{
+		{Title: "a", URL: "https://example.com/a"},
+		{Title: "b", URL: "https://example.com/b"},
+		{Title: "c", URL: "https://example.com/c"},
+		{Title: "d", URL: "https://example.com/d"},
+	}, nil)
+	run.Articles[0].Status = ArticleStatusSaved
+	run.Articles[1].Status = ArticleStatusSkipped
+	run.Articles[2].fail(StageFormat, errors.New("timeout"))
+
+	report := run.Report()
+	if report.Total != 4 || report.Succeeded != 1 || report.Skipped != 1 || len(report.Failures) != 1 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if report.Failures[0].Stage != StageFormat || report.Failures[0].URL != "https://example.com/c" {
+		t.Errorf("unexpected failure %+v", report.Failures[0])
+	}
+	if report.ExceedsThreshold(0.25) {
+		t.Error("1 of 4 exceeds 0.25")
+	}
+	if !report.ExceedsThreshold(0.2) {
+		t.Error("1 of 4 does not exceed 0.2")
+	}
+}
@@ -0,0 +1,222 @@
+package artisum
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	// minTitleSimilarity and minURLSimilarity are how close the title and the URL from the LLM both have to be to a source article.
+	minTitleSimilarity = 0.8
+	minURLSimilarity   = 0.9
+)
+
+// flatJSONObjectRegexp matches the JSON objects without nested objects, which is the shape of a selected article.
+var flatJSONObjectRegexp = regexp.MustCompile(`\{[^{}]*\}`)
+
+// selectionValidator matches the articles the LLM selected back to the articles it was given,
+// so that only articles which really exist in the feeds are summarized.
+type selectionValidator struct {
+	sources []*PromptArticle
+	byURL   map[string]*PromptArticle
+	tags    map[string]*InterestTag
+}
+
+func newSelectionValidator(sources []*PromptArticle, tags []*InterestTag) *selectionValidator {
+	v := &selectionValidator{
+		sources: sources,
+		byURL:   make(map[string]*PromptArticle, len(sources)),
+		tags:    make(map[string]*InterestTag, len(tags)),
+	}
+	for _, s := range sources {
+		v.byURL[CanonicalizeURL(s.URL)] = s
+	}
+	for _, t := range tags {
+		v.tags[strings.ToLower(strings.TrimSpace(t.Name))] = t
+	}
+	return v
+}
+
+// validate returns count articles: the selected ones which match a source article and a tag allowed for it, with the title and URL
+// replaced by the source, topped up with the best of the candidates from the map step when the LLM selected too few.
+// Both the excess and the top-up are ordered by the score weighted by the weight of the source feed.
+func (v *selectionValidator) validate(selected, candidates []*InterestArticle, count int) []*InterestArticle {
+	seen := make(map[string]bool, count)
+	result := make([]*InterestArticle, 0, count)
+	for _, article := range v.repairAll(selected) {
+		if !seen[article.URL] {
+			seen[article.URL] = true
+			result = append(result, article)
+		}
+	}
+	if len(result) > count {
+		v.sortByWeightedScore(result)
+		for _, article := range result[count:] {
+			slog.Info("drop excess selected article", slog.String("url", article.URL), slog.Int("score", article.Score))
+		}
+		result = result[:count]
+	}
+
+	if len(result) < count {
+		candidates = v.repairAll(candidates)
+		v.sortByWeightedScore(candidates)
+		for _, article := range candidates {
+			if len(result) >= count {
+				break
+			}
+			if seen[article.URL] {
+				continue
+			}
+			seen[article.URL] = true
+			slog.Info("top up selected articles", slog.String("url", article.URL), slog.String("from", "candidates"))
+			result = append(result, article)
+		}
+	}
+	if len(result) < count {
+		slog.Warn("fewer articles than requested are selected", slog.Int("selected", len(result)), slog.Int("requested", count))
+	}
+	return result
+}
+
+func (v *selectionValidator) repairAll(articles []*InterestArticle) []*InterestArticle {
+	repaired := make([]*InterestArticle, 0, len(articles))
+	for _, article := range articles {
+		if r, ok := v.repair(article); ok {
+			repaired = append(repaired, r)
+		}
+	}
+	return repaired
+}
+
+// sortByWeightedScore sorts repaired articles, whose URL is the one of the source, by the weighted score.
+func (v *selectionValidator) sortByWeightedScore(articles []*InterestArticle) {
+	weighted := func(a *InterestArticle) float64 {
+		weight := 1.0
+		if source, ok := v.byURL[CanonicalizeURL(a.URL)]; ok && source.Weight > 0 {
+			weight = source.Weight
+		}
+		return float64(a.Score) * weight
+	}
+	sort.SliceStable(articles, func(i, j int) bool { return weighted(articles[i]) > weighted(articles[j]) })
+}
+
+// repair returns a copy of the article with the title and URL of the matching source and the configured tag name.
+func (v *selectionValidator) repair(article *InterestArticle) (*InterestArticle, bool) {
+	if article == nil {
+		return nil, false
+	}
+	source := v.match(article)
+	if source == nil {
+		slog.Warn("drop selected article which is not in the feeds", slog.String("title", article.Title), slog.String("url", article.URL))
+		return nil, false
+	}
+	tag, ok := v.tags[strings.ToLower(strings.TrimSpace(article.Tag))]
+	if !ok {
+		slog.Warn("drop selected article with unknown tag", slog.String("url", source.URL), slog.String("tag", article.Tag))
+		return nil, false
+	}
+	if len(source.AllowedTags) > 0 && !containsFold(source.AllowedTags, tag.Name) {
+		slog.Warn("drop selected article with a tag whose filter rejected it", slog.String("url", source.URL), slog.String("tag", tag.Name))
+		return nil, false
+	}
+
+	repaired := *article
+	if repaired.URL != source.URL || repaired.Title != source.Title {
+		slog.Info("repair selected article", slog.String("title", article.Title), slog.String("url", article.URL), slog.String("source", source.URL))
+	}
+	repaired.Title = source.Title
+	repaired.URL = source.URL
+	repaired.Tag = tag.Name
+	return &repaired, true
+}
+
+// match finds the source article by its URL. A URL which is not the one of a source only matches
+// with the title: the same title, or a URL and a title both close enough to the source, so that
+// a made-up URL is never pointed at a different article.
+func (v *selectionValidator) match(article *InterestArticle) *PromptArticle {
+	if source, ok := v.byURL[CanonicalizeURL(article.URL)]; ok {
+		return source
+	}
+	title := normalizeTitle(article.Title)
+	if title == "" {
+		return nil
+	}
+
+	var (
+		best      *PromptArticle
+		bestScore float64
+	)
+	u, _ := url.Parse(CanonicalizeURL(article.URL))
+	for _, source := range v.sources {
+		titleScore := similarity(normalizeTitle(source.Title), title)
+		if titleScore == 1 {
+			return source
+		}
+		if u == nil || titleScore < minTitleSimilarity {
+			continue
+		}
+		su, err := url.Parse(CanonicalizeURL(source.URL))
+		if err != nil || su.Host != u.Host {
+			continue
+		}
+		if score := similarity(su.Path+"?"+su.RawQuery, u.Path+"?"+u.RawQuery); score >= minURLSimilarity && score+titleScore > bestScore {
+			best, bestScore = source, score+titleScore
+		}
+	}
+	return best
+}
+
+// parseCandidateArticles reads the articles in the free-form responses of the map step.
+func parseCandidateArticles(texts []string) []*InterestArticle {
+	var candidates []*InterestArticle
+	for _, text := range texts {
+		for _, object := range flatJSONObjectRegexp.FindAllString(text, -1) {
+			var article InterestArticle
+			if err := json.Unmarshal([]byte(object), &article); err != nil || article.URL == "" {
+				continue
+			}
+			candidates = append(candidates, &article)
+		}
+	}
+	return candidates
+}
+
+// normalizeTitle keeps only the letters and digits in lower case.
+func normalizeTitle(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, s)
+}
+
+// similarity is 1 minus the Levenshtein distance divided by the length of the longer string.
+func similarity(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) == 0 && len(rb) == 0 {
+		return 1
+	}
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return 1 - float64(prev[len(rb)])/float64(max(len(ra), len(rb)))
+}
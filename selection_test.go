@@ -0,0 +1,180 @@
+package artisum
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+func testSources() []*PromptArticle {
+	return []*PromptArticle{
+		{FeedURL: "https://a.example.com/feed", Weight: 1, URL: "https://a.example.com/posts/12345", Title: "Go 1.22 release notes"},
+		{FeedURL: "https://a.example.com/feed", Weight: 1, URL: "https://a.example.com/posts/2", Title: "Understanding the Go scheduler"},
+		{FeedURL: "https://b.example.com/feed", Weight: 3, URL: "https://b.example.com/rust", Title: "Rust async in depth"},
+	}
+}
+
+func testTags() []*InterestTag {
+	return []*InterestTag{{Name: "golang", Level: 3}, {Name: "rust", Level: 1}}
+}
+
+func urls(articles []*InterestArticle) []string {
+	var result []string
+	for _, a := range articles {
+		result = append(result, a.URL)
+	}
+	return result
+}
+
+func TestSelectionValidatorTopUpByFeedWeight(t *testing.T) {
+	v := newSelectionValidator(testSources(), testTags())
+	candidates := []*InterestArticle{
+		{Title: "Understanding the Go scheduler", Tag: "golang", URL: "https://a.example.com/posts/2", Score: 5},
+		{Title: "Rust async in depth", Tag: "rust", URL: "https://b.example.com/rust", Score: 4},
+	}
+	got := v.validate(nil, candidates, 1)
+	if len(got) != 1 || got[0].URL != "https://b.example.com/rust" {
+		t.Errorf("got %q, want the candidate of the heavier feed", urls(got))
+	}
+}
+
+func TestSelectionValidatorAllowedTags(t *testing.T) {
+	sources := testSources()
+	// The golang filter rejected the scheduler article.
+	sources[1].AllowedTags = []string{"rust"}
+	v := newSelectionValidator(sources, testTags())
+	selected := []*InterestArticle{
+		{Title: "Go 1.22 release notes", Tag: "golang", URL: "https://a.example.com/posts/12345", Score: 8},
+		{Title: "Understanding the Go scheduler", Tag: "golang", URL: "https://a.example.com/posts/2", Score: 9},
+	}
+	candidates := []*InterestArticle{
+		{Title: "Understanding the Go scheduler", Tag: "Golang", URL: "https://a.example.com/posts/2", Score: 9},
+		{Title: "Understanding the Go scheduler", Tag: "RUST", URL: "https://a.example.com/posts/2", Score: 2},
+	}
+	got := v.validate(selected, candidates, 2)
+	if len(got) != 2 || got[0].URL != "https://a.example.com/posts/12345" || got[1].URL != "https://a.example.com/posts/2" || got[1].Tag != "rust" {
+		t.Errorf("got %q, want the scheduler article topped up with its allowed tag", urls(got))
+	}
+}
+
+func TestSimilarity(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want float64
+	}{
+		{"", "", 1},
+		{"abc", "", 0},
+		{"kitten", "sitting", 1 - 3.0/7},
+		{"flaw", "lawn", 0.5},
+		{"golang", "golang", 1},
+		// Runes are compared, not bytes.
+		{"記事要約", "記事の要約", 0.8},
+	}
+	for _, tt := range tests {
+		if got := similarity(tt.a, tt.b); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("similarity(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+		if got := similarity(tt.b, tt.a); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("similarity(%q, %q) = %v, want %v", tt.b, tt.a, got, tt.want)
+		}
+	}
+}
+
+func TestSelectionValidatorMatch(t *testing.T) {
+	v := newSelectionValidator(testSources(), testTags())
+	tests := []struct {
+		name    string
+		article *InterestArticle
+		want    string
+	}{
+		{
+			name:    "canonical url",
+			article: &InterestArticle{Title: "anything", URL: "https://A.example.com/posts/12345/?utm_source=feed"},
+			want:    "https://a.example.com/posts/12345",
+		},
+		{
+			name:    "same title with another url",
+			article: &InterestArticle{Title: "Rust Async in Depth!", URL: "https://b.example.com/made-up"},
+			want:    "https://b.example.com/rust",
+		},
+		{
+			name:    "close url and title",
+			article: &InterestArticle{Title: "Go 1.22 release note", URL: "https://a.example.com/posts/1234"},
+			want:    "https://a.example.com/posts/12345",
+		},
+		{
+			name:    "close url with another title",
+			article: &InterestArticle{Title: "Generics in depth", URL: "https://a.example.com/posts/12346"},
+		},
+		{
+			name:    "close title on another host",
+			article: &InterestArticle{Title: "Go 1.22 release note", URL: "https://c.example.com/posts/12345"},
+		},
+		{
+			name:    "close title with another url",
+			article: &InterestArticle{Title: "Go 1.23 release notes", URL: "https://a.example.com/posts/go-1-23"},
+		},
+		{
+			name:    "no title",
+			article: &InterestArticle{URL: "https://a.example.com/posts/1234"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			if source := v.match(tt.article); source != nil {
+				got = source.URL
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCandidateArticles(t *testing.T) {
+	texts := []string{
+		"Here are the articles.\n```json\n[\n" +
+			`{"Title": "Go 1.22 release notes", "Tag": "golang", "URL": "https://a.example.com/posts/12345", "Score": 8, "Reason": "new release"},` + "\n" +
+			`{"Title": "No URL", "Tag": "golang"},` + "\n" +
+			`{"Title": "Broken", "URL": }` + "\n]\n```",
+		`{"Title": "Rust async in depth", "Tag": "rust", "URL": "https://b.example.com/rust", "Score": "high"}`,
+		"nothing was relevant",
+	}
+	got := parseCandidateArticles(texts)
+	if len(got) != 1 {
+		t.Fatalf("got %q", urls(got))
+	}
+	if a := got[0]; a.Title != "Go 1.22 release notes" || a.Tag != "golang" || a.Score != 8 || a.Reason != "new release" {
+		t.Errorf("got %+v", a)
+	}
+}
+
+func TestSelectionValidatorValidate(t *testing.T) {
+	v := newSelectionValidator(testSources(), testTags())
+	selected := []*InterestArticle{
+		{Title: "Go 1.22 release notes", Tag: "golang", URL: "https://a.example.com/posts/12345", Score: 6},
+		{Title: "Go 1.22 release notes", Tag: "golang", URL: "https://a.example.com/posts/12345/", Score: 6},
+		{Title: "Understanding the Go scheduler", Tag: "golang", URL: "https://a.example.com/posts/2", Score: 9},
+		{Title: "Rust async in depth", Tag: "rust", URL: "https://b.example.com/rust", Score: 4},
+		{Title: "Hallucinated", Tag: "golang", URL: "https://a.example.com/posts/99999", Score: 10},
+		{Title: "Rust async in depth", Tag: "python", URL: "https://b.example.com/rust", Score: 10},
+	}
+
+	// The excess is dropped by the weighted score: 9, 4*3 and 6.
+	got := v.validate(selected, nil, 2)
+	if want := []string{"https://b.example.com/rust", "https://a.example.com/posts/2"}; !slices.Equal(urls(got), want) {
+		t.Errorf("got %q, want %q", urls(got), want)
+	}
+
+	// Too few are topped up from the candidates which are not selected yet.
+	candidates := []*InterestArticle{
+		{Title: "Understanding the Go scheduler", Tag: "golang", URL: "https://a.example.com/posts/2", Score: 9},
+		{Title: "Rust async in depth", Tag: "rust", URL: "https://b.example.com/rust/", Score: 1},
+		{Title: "Hallucinated", Tag: "golang", URL: "https://a.example.com/posts/99999", Score: 10},
+	}
+	got = v.validate(selected[:1], candidates, 5)
+	if want := []string{"https://a.example.com/posts/12345", "https://a.example.com/posts/2", "https://b.example.com/rust"}; !slices.Equal(urls(got), want) {
+		t.Errorf("got %q, want %q", urls(got), want)
+	}
+}
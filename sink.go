@@ -0,0 +1,155 @@
+package artisum
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// SummaryStore is a destination of summaries, such as Notion or local files.
+type SummaryStore interface {
+	// SaveSummaryResult stores the article and returns where it can be read, e.g. the URL of a page, or "".
+	SaveSummaryResult(ctx context.Context, article *SummaryArticle) (string, error)
+}
+
+// ArticleChecker is implemented by stores which know the articles saved before. An article is skipped when any of them has it.
+type ArticleChecker interface {
+	ExistsArticle(ctx context.Context, url string) (bool, error)
+}
+
+// Flusher is implemented by stores which deliver a digest. Flush is called with the articles saved in the run
+// after they are processed, unless the failures exceed the threshold, and again when the run is resumed.
+// It skips the articles progress has as delivered and records the ones it delivers, so that none is delivered twice.
+type Flusher interface {
+	Flush(ctx context.Context, articles []*SummaryArticle, progress FlushProgress) error
+}
+
+// FlushProgress is what a Flusher has delivered, by recipient, e.g. an email address. Flushers with one destination use "".
+type FlushProgress interface {
+	Delivered(recipient, url string) bool
+	// MarkDelivered records the articles as delivered to the recipient. The record is persisted before it returns.
+	MarkDelivered(recipient string, articles []*SummaryArticle) error
+	// Note returns what the flusher noted to resume, e.g. the Slack thread of an article, or "" when nothing is noted.
+	Note(key string) string
+	// SetNotes records the notes. They are persisted before it returns.
+	SetNotes(notes map[string]string) error
+}
+
+// undelivered returns the articles not delivered to the recipient yet.
+func undelivered(articles []*SummaryArticle, progress FlushProgress, recipient string) []*SummaryArticle {
+	var result []*SummaryArticle
+	for _, article := range articles {
+		if !progress.Delivered(recipient, article.Origin.URL) {
+			result = append(result, article)
+		}
+	}
+	return result
+}
+
+// SinkConfig configures a SummaryStore. Options are specific to the type.
+type SinkConfig struct {
+	Type string `json:"type"`
+	// Name identifies the sink in runs and logs. It defaults to the type.
+	Name string `json:"name,omitempty"`
+	// Optional sinks do not fail an article; their failures are reported as warnings.
+	Optional bool            `json:"optional,omitempty"`
+	Options  json.RawMessage `json:"options,omitempty"`
+}
+
+// SinkDeps are what the stores may need besides their options.
+type SinkDeps struct {
+	Summary  *SummarySchema
+	FileRepo *FileRepository
+	// Language is the prompt language, which the digests are written in.
+	Language string
+	Now      time.Time
+}
+
+type SinkFactory func(conf *SinkConfig, deps *SinkDeps) (SummaryStore, error)
+
+var sinkFactories = map[string]SinkFactory{
+	"notion": newNotionSink,
+	"file":   newFileSink,
+	"slack":  newSlackSink,
+	"email":  newEmailSink,
+}
+
+func RegisterSink(typ string, factory SinkFactory) {
+	sinkFactories[typ] = factory
+}
+
+// Sink is a configured SummaryStore.
+type Sink struct {
+	Name     string
+	Optional bool
+	Store    SummaryStore
+}
+
+func NewSinks(confs []*SinkConfig, deps *SinkDeps) ([]*Sink, error) {
+	sinks := make([]*Sink, 0, len(confs))
+	names := make(map[string]bool, len(confs))
+	for _, conf := range confs {
+		factory, ok := sinkFactories[conf.Type]
+		if !ok {
+			return nil, fmt.Errorf("unknown sink type: %q", conf.Type)
+		}
+		if names[conf.Name] {
+			return nil, fmt.Errorf("sink %q is defined twice", conf.Name)
+		}
+		names[conf.Name] = true
+
+		store, err := factory(conf, deps)
+		if err != nil {
+			return nil, fmt.Errorf("sink %s: %w", conf.Name, err)
+		}
+		sinks = append(sinks, &Sink{Name: conf.Name, Optional: conf.Optional, Store: store})
+	}
+	return sinks, nil
+}
+
+// decodeOptions decodes the options of the sink into v, which holds the defaults.
+func (c *SinkConfig) decodeOptions(v any) error {
+	if len(c.Options) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(c.Options, v); err != nil {
+		return fmt.Errorf("invalid options of sink %s: %w", c.Name, err)
+	}
+	return nil
+}
+
+func (c *SinkConfig) setDefaults() {
+	if c.Name == "" {
+		c.Name = c.Type
+	}
+}
+
+// defaultSinks keeps Notion as the only destination of the configs written before sinks were configurable.
+func defaultSinks() []*SinkConfig {
+	return []*SinkConfig{{Type: "notion", Name: "notion"}}
+}
+
+type NotionSinkConfig struct {
+	// TokenEnv and DatabaseIDEnv are the environment variables with the integration token and the database ID.
+	TokenEnv      string `json:"tokenEnv,omitempty"`
+	DatabaseIDEnv string `json:"databaseIdEnv,omitempty"`
+}
+
+func newNotionSink(conf *SinkConfig, deps *SinkDeps) (SummaryStore, error) {
+	options := &NotionSinkConfig{TokenEnv: "NOTION_TOKEN", DatabaseIDEnv: "NOTION_DATABASE_ID"}
+	if err := conf.decodeOptions(options); err != nil {
+		return nil, err
+	}
+	token, databaseID := os.Getenv(options.TokenEnv), os.Getenv(options.DatabaseIDEnv)
+	if token == "" || databaseID == "" {
+		return nil, fmt.Errorf("%s and %s have to be set", options.TokenEnv, options.DatabaseIDEnv)
+	}
+	return NewNotionRepository(token, databaseID, deps.Summary), nil
+}
+
+// newFileSink archives the summaries of each day as JSON and a Markdown digest next to the other local files.
+func newFileSink(_ *SinkConfig, deps *SinkDeps) (SummaryStore, error) {
+	return deps.FileRepo, nil
+}
@@ -0,0 +1,449 @@
+package artisum
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html"
+	"html/template"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	defaultSiteTitle       = "artisum"
+	defaultSiteOutputDir   = ".artisum/site"
+	defaultSiteFeedEntries = 50
+	// siteMarkerFile tells that a directory is a site, which BuildSite may replace.
+	siteMarkerFile = ".artisum-site"
+)
+
+//go:embed templates/site
+var siteFS embed.FS
+
+type SiteConfig struct {
+	Title string `json:"title,omitempty"`
+	// BaseURL is where the site is published. The Atom feed links to the article pages when it is set,
+	// otherwise to the original articles.
+	BaseURL     string `json:"baseUrl,omitempty"`
+	OutputDir   string `json:"outputDir,omitempty"`
+	FeedEntries int    `json:"feedEntries,omitempty"`
+}
+
+type siteArticle struct {
+	*SummaryArticle
+	ID      string
+	Date    string
+	Path    string
+	TagSlug string
+}
+
+type siteDay struct {
+	Date     string
+	Articles []*siteArticle
+}
+
+type siteTag struct {
+	Name     string
+	Slug     string
+	Articles []*siteArticle
+}
+
+// sitePage is the data of every page. Root is the relative path from the page to the top of the site.
+type sitePage struct {
+	Site      *SiteConfig
+	Labels    *summaryLabels
+	Root      string
+	PageTitle string
+	MaxScore  int
+	Tags      []*siteTag
+	Days      []*siteDay
+	Tag       *siteTag
+	Article   *siteArticle
+}
+
+type siteArticleItem struct {
+	*siteArticle
+	Root string
+}
+
+var siteSlugRegexp = regexp.MustCompile(`[^a-z0-9]+`)
+
+// BuildSite renders the archived summaries into a static site: an index by date with client-side search,
+// a page per tag and per article, an Atom feed and the search index. The pages are labeled in language.
+// The site is built next to the output directory and replaces it when it is complete, so that no page of
+// an article or a tag which is gone is left behind. A directory which is not a site is never replaced.
+func BuildSite(conf *SiteConfig, language string, archives []*SummaryArchive) error {
+	if err := checkSiteDir(conf.OutputDir); err != nil {
+		return err
+	}
+	parent := filepath.Dir(filepath.Clean(conf.OutputDir))
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return err
+	}
+	dir, err := os.MkdirTemp(parent, ".artisum-site-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	if err := buildSite(conf, dir, language, archives); err != nil {
+		return err
+	}
+	return replaceDir(dir, conf.OutputDir)
+}
+
+func buildSite(conf *SiteConfig, dir, language string, archives []*SummaryArchive) error {
+	days, tags := siteContents(archives)
+
+	layout, err := template.New("layout").Funcs(map[string]any{
+		"hasPrefix": strings.HasPrefix,
+		"withRoot": func(root string, a *siteArticle) *siteArticleItem {
+			return &siteArticleItem{siteArticle: a, Root: root}
+		},
+	}).ParseFS(siteFS, "templates/site/layout.html.tmpl")
+	if err != nil {
+		return err
+	}
+	render := func(name, path string, page *sitePage) error {
+		t, err := layout.Clone()
+		if err != nil {
+			return err
+		}
+		if _, err := t.ParseFS(siteFS, "templates/site/"+name); err != nil {
+			return err
+		}
+		var b bytes.Buffer
+		if err := t.ExecuteTemplate(&b, "layout", page); err != nil {
+			return fmt.Errorf("failed to render %s: %w", path, err)
+		}
+		return writeSiteFile(dir, path, b.Bytes())
+	}
+	newPage := func(root, title string) *sitePage {
+		return &sitePage{Site: conf, Labels: labelsFor(language), Root: root, PageTitle: title, MaxScore: maxSelectionScore, Tags: tags}
+	}
+
+	index := newPage("", "")
+	index.Days = days
+	if err := render("index.html.tmpl", "index.html", index); err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		page := newPage("../", tag.Name)
+		page.Tag = tag
+		if err := render("tag.html.tmpl", "tags/"+tag.Slug+".html", page); err != nil {
+			return err
+		}
+	}
+	var articles []*siteArticle
+	for _, day := range days {
+		for _, article := range day.Articles {
+			page := newPage("../", article.Origin.Title)
+			page.Article = article
+			if err := render("article.html.tmpl", article.Path, page); err != nil {
+				return err
+			}
+			articles = append(articles, article)
+		}
+	}
+
+	for _, asset := range []string{"style.css", "search.js"} {
+		b, err := fs.ReadFile(siteFS, "templates/site/"+asset)
+		if err != nil {
+			return err
+		}
+		if err := writeSiteFile(dir, asset, b); err != nil {
+			return err
+		}
+	}
+	if err := writeSearchIndex(dir, articles); err != nil {
+		return err
+	}
+	if err := writeAtomFeed(conf, dir, articles); err != nil {
+		return err
+	}
+	if err := writeSiteFile(dir, siteMarkerFile, nil); err != nil {
+		return err
+	}
+
+	slog.Info("built site", slog.String("dir", conf.OutputDir), slog.Int("days", len(days)), slog.Int("articles", len(articles)), slog.Int("tags", len(tags)))
+	return nil
+}
+
+// siteContents merges the archives by date, newest first, and groups the articles by tag.
+// An article in several archives of a day, e.g. of different models, appears once.
+func siteContents(archives []*SummaryArchive) ([]*siteDay, []*siteTag) {
+	byDate := make(map[string]*siteDay)
+	seen := make(map[string]bool)
+	tagsByName := make(map[string]*siteTag)
+	slugs := make(map[string]bool)
+	for _, archive := range archives {
+		day, ok := byDate[archive.Date]
+		if !ok {
+			day = &siteDay{Date: archive.Date}
+			byDate[archive.Date] = day
+		}
+		for _, article := range archive.Articles {
+			if article.Origin == nil || seen[archive.Date+"\n"+article.Origin.URL] {
+				continue
+			}
+			seen[archive.Date+"\n"+article.Origin.URL] = true
+
+			tag, ok := tagsByName[article.Origin.Tag]
+			if !ok {
+				tag = &siteTag{Name: article.Origin.Tag, Slug: siteSlug(article.Origin.Tag, slugs)}
+				tagsByName[article.Origin.Tag] = tag
+			}
+			id := archive.Date + "-" + shortHash(article.Origin.URL)
+			a := &siteArticle{
+				SummaryArticle: article,
+				ID:             id,
+				Date:           archive.Date,
+				Path:           "articles/" + id + ".html",
+				TagSlug:        tag.Slug,
+			}
+			day.Articles = append(day.Articles, a)
+			tag.Articles = append(tag.Articles, a)
+		}
+	}
+
+	days := make([]*siteDay, 0, len(byDate))
+	for _, day := range byDate {
+		if len(day.Articles) > 0 {
+			days = append(days, day)
+		}
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Date > days[j].Date })
+	tags := make([]*siteTag, 0, len(tagsByName))
+	for _, tag := range tagsByName {
+		sort.SliceStable(tag.Articles, func(i, j int) bool { return tag.Articles[i].Date > tag.Articles[j].Date })
+		tags = append(tags, tag)
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i].Name < tags[j].Name })
+	return days, tags
+}
+
+// siteSlug makes a file name of the tag, falling back to a hash for tags without ASCII letters and for collisions.
+func siteSlug(tag string, used map[string]bool) string {
+	slug := strings.Trim(siteSlugRegexp.ReplaceAllString(strings.ToLower(tag), "-"), "-")
+	if slug == "" || used[slug] {
+		slug = strings.TrimPrefix(slug+"-", "-") + shortHash(tag)
+	}
+	used[slug] = true
+	return slug
+}
+
+func shortHash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:4])
+}
+
+// checkSiteDir fails unless dir is missing, empty or a site built before, which has the marker file,
+// or the index and the search index of the sites built before the marker was written.
+func checkSiteDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	names := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		names[e.Name()] = true
+	}
+	if len(entries) == 0 || names[siteMarkerFile] || (names["index.html"] && names["search.json"]) {
+		return nil
+	}
+	return fmt.Errorf("%s is not a site built by artisum; choose another output directory", dir)
+}
+
+// replaceDir moves dir to the place of old, removing old, and puts old back when dir can not be moved.
+func replaceDir(dir, old string) error {
+	if _, err := os.Stat(old); errors.Is(err, os.ErrNotExist) {
+		return os.Rename(dir, old)
+	}
+	backup := dir + ".old"
+	if err := os.Rename(old, backup); err != nil {
+		return err
+	}
+	if err := os.Rename(dir, old); err != nil {
+		if restoreErr := os.Rename(backup, old); restoreErr != nil {
+			return errors.Join(err, restoreErr)
+		}
+		return err
+	}
+	return os.RemoveAll(backup)
+}
+
+func writeSiteFile(dir, path string, b []byte) error {
+	path = filepath.Join(dir, filepath.FromSlash(path))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0644)
+}
+
+type searchEntry struct {
+	Title string `json:"title"`
+	Tag   string `json:"tag"`
+	Date  string `json:"date"`
+	Path  string `json:"path"`
+	URL   string `json:"url"`
+	Text  string `json:"text"`
+}
+
+func writeSearchIndex(dir string, articles []*siteArticle) error {
+	entries := make([]*searchEntry, 0, len(articles))
+	for _, a := range articles {
+		var text []string
+		for _, c := range a.Contents {
+			text = append(text, c.Sentences...)
+		}
+		entries = append(entries, &searchEntry{
+			Title: a.Origin.Title,
+			Tag:   a.Origin.Tag,
+			Date:  a.Date,
+			Path:  a.Path,
+			URL:   a.Origin.URL,
+			Text:  strings.Join(text, " "),
+		})
+	}
+	b, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	return writeSiteFile(dir, "search.json", b)
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Author  atomAuthor  `xml:"author"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title    string       `xml:"title"`
+	ID       string       `xml:"id"`
+	Updated  string       `xml:"updated"`
+	Link     atomLink     `xml:"link"`
+	Category atomCategory `xml:"category"`
+	Content  atomContent  `xml:"content"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+// writeAtomFeed writes the latest summaries as an Atom feed, with the sections as the HTML content.
+func writeAtomFeed(conf *SiteConfig, dir string, articles []*siteArticle) error {
+	baseURL := strings.TrimSuffix(conf.BaseURL, "/")
+	feed := &atomFeed{
+		Title:  conf.Title,
+		ID:     "urn:artisum:" + shortHash(conf.Title),
+		Author: atomAuthor{Name: conf.Title},
+	}
+	if baseURL != "" {
+		feed.ID = baseURL + "/"
+		feed.Links = []atomLink{{Href: baseURL + "/"}, {Href: baseURL + "/feed.xml", Rel: "self"}}
+	}
+
+	for i, a := range articles {
+		if i >= conf.FeedEntries {
+			break
+		}
+		updated := siteDate(a.Date)
+		if feed.Updated == "" || updated > feed.Updated {
+			feed.Updated = updated
+		}
+		entry := atomEntry{
+			Title:    a.Origin.Title,
+			ID:       "urn:artisum:" + a.ID,
+			Updated:  updated,
+			Link:     atomLink{Href: a.Origin.URL},
+			Category: atomCategory{Term: a.Origin.Tag},
+			Content:  atomContent{Type: "html", Body: atomContentHTML(a)},
+		}
+		if baseURL != "" {
+			entry.ID = baseURL + "/" + a.Path
+			entry.Link = atomLink{Href: baseURL + "/" + a.Path}
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+	if feed.Updated == "" {
+		feed.Updated = time.Now().UTC().Format(time.RFC3339)
+	}
+
+	b, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeSiteFile(dir, "feed.xml", append([]byte(xml.Header), b...))
+}
+
+func siteDate(date string) string {
+	t, err := time.Parse(time.DateOnly, date)
+	if err != nil {
+		return time.Now().UTC().Format(time.RFC3339)
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func atomContentHTML(a *siteArticle) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, `<p><a href="%s">%s</a></p>`, html.EscapeString(a.Origin.URL), html.EscapeString(a.Origin.Title))
+	for _, c := range a.Contents {
+		fmt.Fprintf(&b, "<h3>%s</h3>", html.EscapeString(c.Heading))
+		if c.Type == SummaryFieldList {
+			b.WriteString("<ul>")
+			for _, s := range c.Sentences {
+				fmt.Fprintf(&b, "<li>%s</li>", html.EscapeString(s))
+			}
+			b.WriteString("</ul>")
+			continue
+		}
+		fmt.Fprintf(&b, "<p>%s</p>", html.EscapeString(strings.Join(c.Sentences, " ")))
+	}
+	return b.String()
+}
+
+func (c *SiteConfig) setDefaults() {
+	if c.Title == "" {
+		c.Title = defaultSiteTitle
+	}
+	if c.OutputDir == "" {
+		c.OutputDir = defaultSiteOutputDir
+	}
+	if c.FeedEntries <= 0 {
+		c.FeedEntries = defaultSiteFeedEntries
+	}
+}
@@ -0,0 +1,195 @@
+package artisum
+
+import (
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func siteArchives() []*SummaryArchive {
+	articles := testSummaryArticles(4)
+	articles[3].Origin.Tag = "rust"
+	return []*SummaryArchive{
+		{Date: "2024-05-01", Articles: []*SummaryArticle{articles[0], articles[1]}},
+		{Date: "2024-05-02", Articles: []*SummaryArticle{articles[2], articles[3]}},
+		// A second run of the day saves the same article again.
+		{Date: "2024-05-02", Articles: []*SummaryArticle{articles[2], {}}},
+		{Date: "2024-05-03"},
+	}
+}
+
+func siteArticleURLs(articles []*siteArticle) []string {
+	var result []string
+	for _, a := range articles {
+		result = append(result, a.Origin.URL)
+	}
+	return result
+}
+
+func TestSiteContents(t *testing.T) {
+	days, tags := siteContents(siteArchives())
+
+	var dates []string
+	for _, day := range days {
+		dates = append(dates, day.Date)
+	}
+	if want := []string{"2024-05-02", "2024-05-01"}; !slices.Equal(dates, want) {
+		t.Fatalf("dates = %q, want %q", dates, want)
+	}
+	if got, want := siteArticleURLs(days[0].Articles), []string{"https://example.com/2", "https://example.com/3"}; !slices.Equal(got, want) {
+		t.Errorf("articles of %s = %q, want %q", days[0].Date, got, want)
+	}
+
+	if len(tags) != 2 || tags[0].Name != "golang" || tags[1].Name != "rust" {
+		t.Fatalf("tags = %+v", tags)
+	}
+	if got, want := siteArticleURLs(tags[0].Articles), []string{"https://example.com/2", "https://example.com/0", "https://example.com/1"}; !slices.Equal(got, want) {
+		t.Errorf("golang articles = %q, want %q", got, want)
+	}
+	if a := tags[1].Articles[0]; a.TagSlug != "rust" || a.Path != "articles/"+a.ID+".html" || !strings.HasPrefix(a.ID, "2024-05-02-") {
+		t.Errorf("rust article = %+v", a)
+	}
+}
+
+func TestSiteSlug(t *testing.T) {
+	used := make(map[string]bool)
+	tests := []struct {
+		tag  string
+		want string
+	}{
+		{"Go Lang", "go-lang"},
+		{"C++", "c"},
+		{"機械学習", shortHash("機械学習")},
+		{"go-lang", "go-lang-" + shortHash("go-lang")},
+		{"AI と LLM", "ai-llm"},
+	}
+	for _, tt := range tests {
+		if got := siteSlug(tt.tag, used); got != tt.want {
+			t.Errorf("siteSlug(%q) = %q, want %q", tt.tag, got, tt.want)
+		}
+	}
+}
+
+func readAtomFeed(t *testing.T, path string) *atomFeed {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var feed atomFeed
+	if err := xml.Unmarshal(b, &feed); err != nil {
+		t.Fatal(err)
+	}
+	return &feed
+}
+
+func TestWriteAtomFeed(t *testing.T) {
+	days, _ := siteContents(siteArchives())
+	var articles []*siteArticle
+	for _, day := range days {
+		articles = append(articles, day.Articles...)
+	}
+
+	t.Run("base url", func(t *testing.T) {
+		dir := t.TempDir()
+		conf := &SiteConfig{Title: "Digest", BaseURL: "https://digest.example.com/", FeedEntries: 3}
+		if err := writeAtomFeed(conf, dir, articles); err != nil {
+			t.Fatal(err)
+		}
+		feed := readAtomFeed(t, filepath.Join(dir, "feed.xml"))
+		if feed.Title != "Digest" || feed.ID != "https://digest.example.com/" || feed.Updated != "2024-05-02T00:00:00Z" {
+			t.Errorf("feed = %+v", feed)
+		}
+		if len(feed.Links) != 2 || feed.Links[1].Href != "https://digest.example.com/feed.xml" || feed.Links[1].Rel != "self" {
+			t.Errorf("links = %+v", feed.Links)
+		}
+		if len(feed.Entries) != 3 {
+			t.Fatalf("got %d entries, want the limit 3", len(feed.Entries))
+		}
+		entry := feed.Entries[0]
+		if want := "https://digest.example.com/" + articles[0].Path; entry.Link.Href != want || entry.ID != want {
+			t.Errorf("entry links to %q with id %q, want %q", entry.Link.Href, entry.ID, want)
+		}
+		if entry.Title != "Article 2" || entry.Category.Term != "golang" || entry.Updated != "2024-05-02T00:00:00Z" {
+			t.Errorf("entry = %+v", entry)
+		}
+		if want := `<h3>Summary</h3><p>About &lt;generics&gt; &amp; more.</p><h3>Keywords</h3><ul><li>go</li><li>generics</li></ul>`; entry.Content.Type != "html" || !strings.Contains(entry.Content.Body, want) {
+			t.Errorf("content = %q, want %q", entry.Content.Body, want)
+		}
+	})
+
+	t.Run("no base url", func(t *testing.T) {
+		dir := t.TempDir()
+		conf := &SiteConfig{Title: "Digest", FeedEntries: 10}
+		if err := writeAtomFeed(conf, dir, articles); err != nil {
+			t.Fatal(err)
+		}
+		feed := readAtomFeed(t, filepath.Join(dir, "feed.xml"))
+		if len(feed.Links) != 0 || !strings.HasPrefix(feed.ID, "urn:artisum:") {
+			t.Errorf("feed = %+v", feed)
+		}
+		if len(feed.Entries) != len(articles) {
+			t.Fatalf("got %d entries, want %d", len(feed.Entries), len(articles))
+		}
+		if entry := feed.Entries[0]; entry.Link.Href != "https://example.com/2" || entry.ID != "urn:artisum:"+articles[0].ID {
+			t.Errorf("entry = %+v", entry)
+		}
+	})
+}
+
+func TestBuildSiteReplacesOutput(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "site")
+	conf := &SiteConfig{OutputDir: out}
+	conf.setDefaults()
+
+	archives := siteArchives()
+	if err := BuildSite(conf, "en", archives); err != nil {
+		t.Fatal(err)
+	}
+	days, tags := siteContents(archives)
+	stale := []string{"tags/" + tags[1].Slug + ".html", days[0].Articles[1].Path}
+	for _, path := range append([]string{"index.html", "feed.xml", "search.json", "style.css", "search.js"}, stale...) {
+		if _, err := os.Stat(filepath.Join(out, path)); err != nil {
+			t.Errorf("%s is not built: %v", path, err)
+		}
+	}
+
+	// The rust article is gone, and so are its page and the page of its tag.
+	archives[1].Articles = archives[1].Articles[:1]
+	if err := BuildSite(conf, "en", archives); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range stale {
+		if _, err := os.Stat(filepath.Join(out, path)); !os.IsNotExist(err) {
+			t.Errorf("%s is left behind: %v", path, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(out, "index.html")); err != nil {
+		t.Errorf("index.html is not built: %v", err)
+	}
+	entries, err := os.ReadDir(filepath.Dir(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("temporary directories are left behind: %v", entries)
+	}
+}
+
+func TestBuildSiteKeepsOtherDirectories(t *testing.T) {
+	out := t.TempDir()
+	if err := os.WriteFile(filepath.Join(out, "notes.txt"), []byte("keep"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	conf := &SiteConfig{OutputDir: out}
+	conf.setDefaults()
+	if err := BuildSite(conf, "en", siteArchives()); err == nil {
+		t.Fatal("a directory which is not a site is replaced")
+	}
+	if b, err := os.ReadFile(filepath.Join(out, "notes.txt")); err != nil || string(b) != "keep" {
+		t.Errorf("notes.txt = %q, %v", b, err)
+	}
+}
@@ -0,0 +1,289 @@
+package artisum
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	defaultSlackAPIURL = "https://slack.com/api"
+	slackTimeout       = 30 * time.Second
+	// slackTextLimit is the maximum length of the text of a section block.
+	slackTextLimit = 3000
+	// slackMaxBlocks is the maximum number of blocks of a message.
+	slackMaxBlocks = 50
+	// slackBlocksPerArticle are the divider, section and context blocks of an article in the digest.
+	slackBlocksPerArticle = 3
+	// slackArticlesPerMessage keeps a digest message, which starts with a header block, within slackMaxBlocks.
+	slackArticlesPerMessage = (slackMaxBlocks - 1) / slackBlocksPerArticle
+)
+
+type SlackSinkConfig struct {
+	// WebhookURLEnv is the environment variable with the URL of the incoming webhook. WebhookURL is used when it is not set.
+	WebhookURLEnv string `json:"webhookUrlEnv,omitempty"`
+	WebhookURL    string `json:"webhookUrl,omitempty"`
+	// Thread posts the full summary of each article as a reply to the digest. Incoming webhooks can not reply,
+	// so it posts with chat.postMessage, which needs a bot token and a channel.
+	Thread   bool   `json:"thread,omitempty"`
+	TokenEnv string `json:"tokenEnv,omitempty"`
+	Channel  string `json:"channel,omitempty"`
+	APIURL   string `json:"apiUrl,omitempty"`
+	// SummaryField and KeywordsField are the summary fields shown in the digest.
+	SummaryField  string `json:"summaryField,omitempty"`
+	KeywordsField string `json:"keywordsField,omitempty"`
+	// LinkSink is the sink whose location is linked from the digest, e.g. the Notion page.
+	LinkSink string `json:"linkSink,omitempty"`
+}
+
+// SlackSink posts a digest of the summaries of a run to Slack.
+type SlackSink struct {
+	conf       *SlackSinkConfig
+	client     *http.Client
+	webhookURL string
+	token      string
+	date       string
+	labels     *summaryLabels
+}
+
+func newSlackSink(conf *SinkConfig, deps *SinkDeps) (SummaryStore, error) {
+	options := &SlackSinkConfig{
+		WebhookURLEnv: "SLACK_WEBHOOK_URL",
+		TokenEnv:      "SLACK_BOT_TOKEN",
+		APIURL:        defaultSlackAPIURL,
+		SummaryField:  "summary",
+		KeywordsField: "keywords",
+		LinkSink:      "notion",
+	}
+	if err := conf.decodeOptions(options); err != nil {
+		return nil, err
+	}
+	return NewSlackSink(options, deps.Language, deps.Now)
+}
+
+func NewSlackSink(conf *SlackSinkConfig, language string, now time.Time) (*SlackSink, error) {
+	s := &SlackSink{
+		conf:   conf,
+		client: &http.Client{Timeout: slackTimeout},
+		date:   now.Format(time.DateOnly),
+		labels: labelsFor(language),
+	}
+	if conf.Thread {
+		s.token = os.Getenv(conf.TokenEnv)
+		if s.token == "" || conf.Channel == "" {
+			return nil, fmt.Errorf("thread needs %s and a channel", conf.TokenEnv)
+		}
+		return s, nil
+	}
+	s.webhookURL = os.Getenv(conf.WebhookURLEnv)
+	if s.webhookURL == "" {
+		s.webhookURL = conf.WebhookURL
+	}
+	if s.webhookURL == "" {
+		return nil, fmt.Errorf("%s or webhookUrl has to be set", conf.WebhookURLEnv)
+	}
+	return s, nil
+}
+
+// SaveSummaryResult does nothing, as the articles are posted together by Flush.
+func (s *SlackSink) SaveSummaryResult(context.Context, *SummaryArticle) (string, error) {
+	return "", nil
+}
+
+// Flush posts the articles not posted yet in digests of up to slackArticlesPerMessage articles.
+// A digest is recorded as delivered once it is posted, or in a thread, each article once its reply is posted.
+// The thread of each article is noted, so that a resumed flush only adds the missing replies to it.
+func (s *SlackSink) Flush(ctx context.Context, articles []*SummaryArticle, progress FlushProgress) error {
+	var fresh []*SummaryArticle
+	for _, article := range undelivered(articles, progress, "") {
+		if ts := progress.Note(slackThreadNote(article)); s.conf.Thread && ts != "" {
+			if err := s.reply(ctx, ts, article, progress); err != nil {
+				return err
+			}
+			continue
+		}
+		fresh = append(fresh, article)
+	}
+
+	for start := 0; start < len(fresh); start += slackArticlesPerMessage {
+		batch := fresh[start:min(start+slackArticlesPerMessage, len(fresh))]
+		ts, err := s.post(ctx, &slackMessage{
+			Text:   fmt.Sprintf("%s (%s)", s.labels.DigestTitle(s.date), s.labels.ArticleCount(len(batch))),
+			Blocks: s.digestBlocks(batch),
+		})
+		if err != nil {
+			return err
+		}
+		if !s.conf.Thread {
+			if err := progress.MarkDelivered("", batch); err != nil {
+				return err
+			}
+			continue
+		}
+
+		notes := make(map[string]string, len(batch))
+		for _, article := range batch {
+			notes[slackThreadNote(article)] = ts
+		}
+		if err := progress.SetNotes(notes); err != nil {
+			return err
+		}
+		for _, article := range batch {
+			if err := s.reply(ctx, ts, article, progress); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// reply posts the full summary of the article to the thread of the digest ts and records it as delivered.
+func (s *SlackSink) reply(ctx context.Context, ts string, article *SummaryArticle, progress FlushProgress) error {
+	if _, err := s.post(ctx, &slackMessage{
+		Text:     article.Origin.Title,
+		Blocks:   s.detailBlocks(article),
+		ThreadTS: ts,
+	}); err != nil {
+		return err
+	}
+	return progress.MarkDelivered("", []*SummaryArticle{article})
+}
+
+func slackThreadNote(article *SummaryArticle) string {
+	return "thread:" + article.Origin.URL
+}
+
+type slackMessage struct {
+	Channel  string       `json:"channel,omitempty"`
+	Text     string       `json:"text"`
+	Blocks   []slackBlock `json:"blocks,omitempty"`
+	ThreadTS string       `json:"thread_ts,omitempty"`
+}
+
+type slackBlock struct {
+	Type     string      `json:"type"`
+	Text     *slackText  `json:"text,omitempty"`
+	Elements []slackText `json:"elements,omitempty"`
+}
+
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+func (s *SlackSink) digestBlocks(articles []*SummaryArticle) []slackBlock {
+	blocks := []slackBlock{{
+		Type: "header",
+		Text: &slackText{Type: "plain_text", Text: s.labels.DigestTitle(s.date)},
+	}}
+	for _, article := range articles {
+		var b strings.Builder
+		fmt.Fprintf(&b, "*<%s|%s>*  `%s`", article.Origin.URL, slackEscape(article.Origin.Title), slackEscape(article.Origin.Tag))
+		if c := findContent(article.Contents, s.conf.SummaryField); c != nil {
+			fmt.Fprintf(&b, "\n%s", slackEscape(strings.Join(c.Sentences, " ")))
+		}
+		block := slackBlock{Type: "section", Text: &slackText{Type: "mrkdwn", Text: truncate(b.String(), slackTextLimit)}}
+		blocks = append(blocks, slackBlock{Type: "divider"}, block)
+
+		var footer []string
+		if c := findContent(article.Contents, s.conf.KeywordsField); c != nil && len(c.Sentences) > 0 {
+			footer = append(footer, s.labels.Keywords+": "+slackEscape(strings.Join(c.Sentences, ", ")))
+		}
+		if location, ok := article.Locations[s.conf.LinkSink]; ok && strings.HasPrefix(location, "http") {
+			footer = append(footer, fmt.Sprintf("<%s|%s>", location, s.labels.ReadOn(s.conf.LinkSink)))
+		}
+		if len(footer) > 0 {
+			blocks = append(blocks, slackBlock{Type: "context", Elements: []slackText{{Type: "mrkdwn", Text: truncate(strings.Join(footer, "  |  "), slackTextLimit)}}})
+		}
+	}
+	return blocks
+}
+
+// detailBlocks is the full summary of the article, a section per content up to slackMaxBlocks.
+func (s *SlackSink) detailBlocks(article *SummaryArticle) []slackBlock {
+	var blocks []slackBlock
+	for _, c := range article.Contents[:min(len(article.Contents), slackMaxBlocks)] {
+		var b strings.Builder
+		fmt.Fprintf(&b, "*%s*\n", slackEscape(c.Heading))
+		if c.Type == SummaryFieldList {
+			for _, sentence := range c.Sentences {
+				fmt.Fprintf(&b, "• %s\n", slackEscape(sentence))
+			}
+		} else {
+			b.WriteString(slackEscape(strings.Join(c.Sentences, "\n")))
+		}
+		blocks = append(blocks, slackBlock{Type: "section", Text: &slackText{Type: "mrkdwn", Text: truncate(b.String(), slackTextLimit)}})
+	}
+	return blocks
+}
+
+// post sends the message and returns its timestamp, which is only known when it is posted with chat.postMessage.
+func (s *SlackSink) post(ctx context.Context, message *slackMessage) (string, error) {
+	url := s.webhookURL
+	if s.conf.Thread {
+		url = strings.TrimSuffix(s.conf.APIURL, "/") + "/chat.postMessage"
+		message.Channel = s.conf.Channel
+	}
+	body, err := json.Marshal(message)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode/100 != 2 {
+		return "", fmt.Errorf("slack responded %s: %s", resp.Status, strings.TrimSpace(string(respBody)))
+	}
+	if !s.conf.Thread {
+		return "", nil
+	}
+
+	var result struct {
+		OK    bool   `json:"ok"`
+		TS    string `json:"ts"`
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return "", err
+	}
+	if !result.OK {
+		return "", errors.New("slack responded with error: " + result.Error)
+	}
+	return result.TS, nil
+}
+
+func findContent(contents []*FormatContent, field string) *FormatContent {
+	for _, c := range contents {
+		if c.Field == field {
+			return c
+		}
+	}
+	return nil
+}
+
+// slackEscape escapes the characters which have a meaning in mrkdwn.
+func slackEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
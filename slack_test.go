@@ -0,0 +1,256 @@
+package artisum
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryFlushProgress keeps the progress of a flush in memory.
+type memoryFlushProgress struct {
+	delivered map[string][]string
+	notes     map[string]string
+}
+
+func (p *memoryFlushProgress) Delivered(recipient, url string) bool {
+	return slices.Contains(p.delivered[recipient], url)
+}
+
+func (p *memoryFlushProgress) MarkDelivered(recipient string, articles []*SummaryArticle) error {
+	if p.delivered == nil {
+		p.delivered = make(map[string][]string)
+	}
+	for _, article := range articles {
+		p.delivered[recipient] = append(p.delivered[recipient], article.Origin.URL)
+	}
+	return nil
+}
+
+func (p *memoryFlushProgress) Note(key string) string {
+	return p.notes[key]
+}
+
+func (p *memoryFlushProgress) SetNotes(notes map[string]string) error {
+	if p.notes == nil {
+		p.notes = make(map[string]string)
+	}
+	for key, value := range notes {
+		p.notes[key] = value
+	}
+	return nil
+}
+
+func testSummaryArticles(n int) []*SummaryArticle {
+	articles := make([]*SummaryArticle, n)
+	for i := range articles {
+		articles[i] = &SummaryArticle{
+			Origin: &InterestArticle{Title: fmt.Sprintf("Article %d", i), URL: fmt.Sprintf("https://example.com/%d", i), Tag: "golang"},
+			Contents: []*FormatContent{
+				{Heading: "Summary", Field: "summary", Type: SummaryFieldText, Sentences: []string{"About <generics> & more."}},
+				{Heading: "Keywords", Field: "keywords", Type: SummaryFieldList, Sentences: []string{"go", "generics"}},
+			},
+			Locations: map[string]string{"notion": fmt.Sprintf("https://notion.so/%d", i)},
+		}
+	}
+	return articles
+}
+
+// slackStub records the messages posted to it and fails the requests whose number is in failAt.
+type slackStub struct {
+	mu       sync.Mutex
+	messages []*slackMessage
+	auth     []string
+	failAt   map[int]bool
+	requests int
+}
+
+func (s *slackStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+	if s.failAt[s.requests] {
+		http.Error(w, "rate_limited", http.StatusTooManyRequests)
+		return
+	}
+	var message slackMessage
+	if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.messages = append(s.messages, &message)
+	s.auth = append(s.auth, r.Header.Get("Authorization"))
+	if r.URL.Path == "/api/chat.postMessage" {
+		fmt.Fprintf(w, `{"ok": true, "ts": "%d.000"}`, len(s.messages))
+		return
+	}
+	_, _ = w.Write([]byte("ok"))
+}
+
+func TestSlackSinkWebhook(t *testing.T) {
+	stub := &slackStub{failAt: map[int]bool{2: true}}
+	server := httptest.NewServer(stub)
+	defer server.Close()
+
+	conf := &SlackSinkConfig{WebhookURL: server.URL + "/webhook", SummaryField: "summary", KeywordsField: "keywords", LinkSink: "notion"}
+	sink, err := NewSlackSink(conf, "en", time.Date(2024, 8, 13, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	articles := testSummaryArticles(20)
+	progress := &memoryFlushProgress{}
+
+	// The second digest fails, and the first is kept as delivered.
+	if err := sink.Flush(context.Background(), articles, progress); err == nil || !strings.Contains(err.Error(), "429") {
+		t.Fatalf("err = %v", err)
+	}
+	if len(stub.messages) != 1 || len(progress.delivered[""]) != slackArticlesPerMessage {
+		t.Fatalf("posted %d messages, delivered %d articles", len(stub.messages), len(progress.delivered[""]))
+	}
+
+	// The resumed flush posts only the rest.
+	if err := sink.Flush(context.Background(), articles, progress); err != nil {
+		t.Fatal(err)
+	}
+	if len(stub.messages) != 2 || len(progress.delivered[""]) != 20 {
+		t.Fatalf("posted %d messages, delivered %d articles", len(stub.messages), len(progress.delivered[""]))
+	}
+	for i, message := range stub.messages {
+		if len(message.Blocks) > slackMaxBlocks {
+			t.Errorf("message %d has %d blocks", i, len(message.Blocks))
+		}
+	}
+	second := stub.messages[1]
+	if second.Text != "Article summaries for 2024-08-13 (4 articles)" || second.Channel != "" || second.ThreadTS != "" {
+		t.Errorf("message = %+v", second)
+	}
+	if got := len(second.Blocks); got != 1+4*slackBlocksPerArticle {
+		t.Errorf("got %d blocks", got)
+	}
+	section, footer := second.Blocks[2].Text.Text, second.Blocks[3].Elements[0].Text
+	if !strings.Contains(section, "*<https://example.com/16|Article 16>*") || !strings.Contains(section, "About &lt;generics&gt; &amp; more.") {
+		t.Errorf("section = %q", section)
+	}
+	if footer != "Keywords: go, generics  |  <https://notion.so/16|Read on notion>" {
+		t.Errorf("footer = %q", footer)
+	}
+
+	if err := sink.Flush(context.Background(), articles, progress); err != nil || len(stub.messages) != 2 {
+		t.Errorf("flushed again: %d messages, %v", len(stub.messages), err)
+	}
+}
+
+func TestSlackSinkThread(t *testing.T) {
+	stub := &slackStub{}
+	server := httptest.NewServer(stub)
+	defer server.Close()
+	t.Setenv("TEST_SLACK_BOT_TOKEN", "xoxb-test")
+
+	conf := &SlackSinkConfig{Thread: true, TokenEnv: "TEST_SLACK_BOT_TOKEN", Channel: "C123", APIURL: server.URL + "/api/", SummaryField: "summary"}
+	sink, err := NewSlackSink(conf, "ja", time.Date(2024, 8, 13, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	progress := &memoryFlushProgress{}
+	if err := sink.Flush(context.Background(), testSummaryArticles(2), progress); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(stub.messages) != 3 {
+		t.Fatalf("posted %d messages, want a digest and 2 replies", len(stub.messages))
+	}
+	digest := stub.messages[0]
+	if digest.Channel != "C123" || digest.ThreadTS != "" || digest.Text != "2024-08-13 の記事要約 (2件)" {
+		t.Errorf("digest = %+v", digest)
+	}
+	for i, reply := range stub.messages[1:] {
+		if reply.Channel != "C123" || reply.ThreadTS != "1.000" || reply.Text != fmt.Sprintf("Article %d", i) {
+			t.Errorf("reply = %+v", reply)
+		}
+		if len(reply.Blocks) != 2 || reply.Blocks[1].Text.Text != "*Keywords*\n• go\n• generics\n" {
+			t.Errorf("blocks = %+v", reply.Blocks)
+		}
+	}
+	for _, auth := range stub.auth {
+		if auth != "Bearer xoxb-test" {
+			t.Errorf("authorization = %q", auth)
+		}
+	}
+	if len(progress.delivered[""]) != 2 {
+		t.Errorf("delivered %q", progress.delivered[""])
+	}
+}
+
+func TestSlackSinkThreadPartialFailure(t *testing.T) {
+	// The digest and the first reply are posted, and the second reply fails.
+	stub := &slackStub{failAt: map[int]bool{3: true}}
+	server := httptest.NewServer(stub)
+	defer server.Close()
+	t.Setenv("TEST_SLACK_BOT_TOKEN", "xoxb-test")
+
+	conf := &SlackSinkConfig{Thread: true, TokenEnv: "TEST_SLACK_BOT_TOKEN", Channel: "C123", APIURL: server.URL + "/api", SummaryField: "summary"}
+	sink, err := NewSlackSink(conf, "en", time.Date(2024, 8, 13, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	articles := testSummaryArticles(3)
+	progress := &memoryFlushProgress{}
+	if err := sink.Flush(context.Background(), articles, progress); err == nil || !strings.Contains(err.Error(), "429") {
+		t.Fatalf("err = %v", err)
+	}
+	if want := []string{"https://example.com/0"}; !slices.Equal(progress.delivered[""], want) {
+		t.Fatalf("delivered %q, want %q", progress.delivered[""], want)
+	}
+	for _, article := range articles {
+		if ts := progress.Note(slackThreadNote(article)); ts != "1.000" {
+			t.Errorf("thread of %s = %q", article.Origin.URL, ts)
+		}
+	}
+
+	// The resumed flush adds only the missing replies to the thread.
+	if err := sink.Flush(context.Background(), articles, progress); err != nil {
+		t.Fatal(err)
+	}
+	if len(stub.messages) != 4 {
+		t.Fatalf("posted %d messages, want a digest and 3 replies", len(stub.messages))
+	}
+	for i, reply := range stub.messages[1:] {
+		if reply.ThreadTS != "1.000" || reply.Text != fmt.Sprintf("Article %d", i) {
+			t.Errorf("reply = %+v", reply)
+		}
+	}
+	if len(progress.delivered[""]) != 3 {
+		t.Errorf("delivered %q", progress.delivered[""])
+	}
+
+	if err := sink.Flush(context.Background(), articles, progress); err != nil || len(stub.messages) != 4 {
+		t.Errorf("flushed again: %d messages, %v", len(stub.messages), err)
+	}
+}
+
+func TestSlackSinkThreadError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"ok": false, "error": "channel_not_found"}`))
+	}))
+	defer server.Close()
+	t.Setenv("TEST_SLACK_BOT_TOKEN", "xoxb-test")
+
+	conf := &SlackSinkConfig{Thread: true, TokenEnv: "TEST_SLACK_BOT_TOKEN", Channel: "C123", APIURL: server.URL}
+	sink, err := NewSlackSink(conf, "ja", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	progress := &memoryFlushProgress{}
+	if err := sink.Flush(context.Background(), testSummaryArticles(1), progress); err == nil || !strings.Contains(err.Error(), "channel_not_found") {
+		t.Errorf("err = %v", err)
+	}
+	if len(progress.delivered) != 0 {
+		t.Errorf("delivered %v", progress)
+	}
+}
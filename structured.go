@@ -0,0 +1,195 @@
+package artisum
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"strings"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+const maxRepairAttempts = 2
+
+// OutputSchema describes the JSON object an LLM has to respond with.
+// It is sent as a tool definition when the provider supports tool calling, otherwise it is put in the prompt.
+type OutputSchema struct {
+	Name        string
+	Description string
+	Parameters  map[string]any
+	// Validate checks what depends on the caller, e.g. the fields of a configured summary. It is run after the Validate method of the output.
+	Validate func(v any) error
+}
+
+type validatable interface {
+	Validate() error
+}
+
+// StructuredOutput asks an LLM for JSON conforming to an OutputSchema.
+// When the response can not be decoded or validated, the error is fed back up to maxRepairAttempts times,
+// to the JSONRepairer if there is one, otherwise to the model which wrote the response.
+type StructuredOutput struct {
+	llm          llms.Model
+	capabilities LLMCapabilities
+	repairer     *JSONRepairer
+}
+
+// NewStructuredOutput returns a StructuredOutput which repairs invalid output with repairer, or with llm itself when repairer is nil.
+func NewStructuredOutput(llm llms.Model, conf *LLMConfig, repairer *JSONRepairer) *StructuredOutput {
+	return &StructuredOutput{
+		llm:          llm,
+		capabilities: llmCapabilities[conf.Provider],
+		repairer:     repairer,
+	}
+}
+
+// Generate decodes the response to prompt into out and validates it with its Validate method, if any, and schema.Validate.
+func (s *StructuredOutput) Generate(ctx context.Context, prompt string, schema *OutputSchema, out any) error {
+	prompt, err := withSchemaInstruction(prompt, schema, s.capabilities)
+	if err != nil {
+		return err
+	}
+	messages := []llms.MessageContent{llms.TextParts(llms.ChatMessageTypeHuman, prompt)}
+	raw, err := generateStructured(ctx, s.llm, s.capabilities, messages, schema)
+	if err != nil {
+		return err
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := decodeStructuredOutput(raw, schema, out)
+		if err == nil {
+			return nil
+		}
+		if attempt >= maxRepairAttempts {
+			return fmt.Errorf("invalid %s output after %d attempts: %w", schema.Name, attempt+1, err)
+		}
+
+		slog.Info("repair structured output", slog.String("schema", schema.Name), slog.Int("attempt", attempt+1), slog.String("error", err.Error()))
+		if s.repairer != nil {
+			raw, err = s.repairer.Repair(ctx, schema, raw, err)
+		} else {
+			messages = append(messages,
+				llms.TextParts(llms.ChatMessageTypeAI, raw),
+				llms.TextParts(llms.ChatMessageTypeHuman, fmt.Sprintf(
+					"The JSON is invalid for the following reasons.\n%s\n\nPlease respond again with the corrected JSON, keeping the valid parts as they are.", err)),
+			)
+			raw, err = generateStructured(ctx, s.llm, s.capabilities, messages, schema)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// JSONRepairer fixes invalid JSON on its own model. It only sees the schema, the JSON and the errors,
+// not the prompt the JSON was written for, so that a cheap model with a small context window is enough.
+type JSONRepairer struct {
+	llm          llms.Model
+	capabilities LLMCapabilities
+}
+
+func NewJSONRepairer(llm llms.Model, conf *LLMConfig) *JSONRepairer {
+	return &JSONRepairer{
+		llm:          llm,
+		capabilities: llmCapabilities[conf.Provider],
+	}
+}
+
+// Repair returns the JSON fixed for the reasons in invalid.
+func (r *JSONRepairer) Repair(ctx context.Context, schema *OutputSchema, raw string, invalid error) (string, error) {
+	prompt := fmt.Sprintf("The following JSON is invalid for the following reasons.\n%s\n\nJSON:\n%s\n\n"+
+		"Correct the JSON, keeping the valid parts as they are.", invalid, raw)
+	// The schema is put in the prompt even with tool calling, since the model has nothing else to go by.
+	prompt, err := withSchemaInstruction(prompt, schema, LLMCapabilities{})
+	if err != nil {
+		return "", err
+	}
+	messages := []llms.MessageContent{llms.TextParts(llms.ChatMessageTypeHuman, prompt)}
+	return generateStructured(ctx, r.llm, r.capabilities, messages, schema)
+}
+
+// withSchemaInstruction puts the schema in the prompt unless it is sent as a tool definition.
+func withSchemaInstruction(prompt string, schema *OutputSchema, capabilities LLMCapabilities) (string, error) {
+	if capabilities.ToolCalling {
+		return prompt, nil
+	}
+	schemaJSON, err := json.MarshalIndent(schema.Parameters, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return prompt + "\n\nRespond with only a JSON object which conforms to the following JSON schema.\n" + string(schemaJSON), nil
+}
+
+func generateStructured(ctx context.Context, llm llms.Model, capabilities LLMCapabilities, messages []llms.MessageContent, schema *OutputSchema) (string, error) {
+	var opts []llms.CallOption
+	switch {
+	case capabilities.ToolCalling:
+		opts = append(opts, llms.WithTools([]llms.Tool{{
+			Type: "function",
+			Function: &llms.FunctionDefinition{
+				Name:        schema.Name,
+				Description: schema.Description,
+				Parameters:  schema.Parameters,
+			},
+		}}), llms.WithToolChoice(llms.ToolChoice{
+			// The tool is forced, as a model free to choose may answer in text and use up the repair attempts.
+			Type:     "function",
+			Function: &llms.FunctionReference{Name: schema.Name},
+		}))
+	case capabilities.JSONMode:
+		opts = append(opts, llms.WithJSONMode())
+	}
+
+	resp, err := llm.GenerateContent(ctx, messages, opts...)
+	if err != nil {
+		return "", err
+	}
+	if len(resp.Choices) == 0 {
+		return "", errors.New("empty response from llm")
+	}
+	choice := resp.Choices[0]
+	for _, call := range choice.ToolCalls {
+		if call.FunctionCall != nil && call.FunctionCall.Name == schema.Name {
+			return call.FunctionCall.Arguments, nil
+		}
+	}
+	return choice.Content, nil
+}
+
+func decodeStructuredOutput(raw string, schema *OutputSchema, out any) error {
+	raw = extractJSONObject(raw)
+	if raw == "" {
+		return errors.New("the response does not contain a JSON object")
+	}
+	// Decode into a new value so that nothing is left over from a previous attempt.
+	v := reflect.New(reflect.TypeOf(out).Elem())
+	decoder := json.NewDecoder(strings.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v.Interface()); err != nil {
+		return err
+	}
+	if validatable, ok := v.Interface().(validatable); ok {
+		if err := validatable.Validate(); err != nil {
+			return err
+		}
+	}
+	if schema.Validate != nil {
+		if err := schema.Validate(v.Interface()); err != nil {
+			return err
+		}
+	}
+	reflect.ValueOf(out).Elem().Set(v.Elem())
+	return nil
+}
+
+// extractJSONObject strips the text models tend to put around JSON, e.g. code fences or an explanation.
+func extractJSONObject(s string) string {
+	start, end := strings.Index(s, "{"), strings.LastIndex(s, "}")
+	if start < 0 || end < start {
+		return ""
+	}
+	return s[start : end+1]
+}
@@ -0,0 +1,167 @@
+package artisum
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// scriptedLLM answers with its responses in order and records the messages it was sent.
+type scriptedLLM struct {
+	responses []*llms.ContentChoice
+	calls     [][]llms.MessageContent
+	options   []llms.CallOptions
+}
+
+func (m *scriptedLLM) GenerateContent(_ context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	if len(m.calls) >= len(m.responses) {
+		return nil, errors.New("no more responses")
+	}
+	var opts llms.CallOptions
+	for _, o := range options {
+		o(&opts)
+	}
+	m.calls = append(m.calls, messages)
+	m.options = append(m.options, opts)
+	return &llms.ContentResponse{Choices: []*llms.ContentChoice{m.responses[len(m.calls)-1]}}, nil
+}
+
+func (m *scriptedLLM) Call(ctx context.Context, prompt string, options ...llms.CallOption) (string, error) {
+	return llms.GenerateFromSinglePrompt(ctx, m, prompt, options...)
+}
+
+func scripted(contents ...string) *scriptedLLM {
+	m := &scriptedLLM{}
+	for _, c := range contents {
+		m.responses = append(m.responses, &llms.ContentChoice{Content: c})
+	}
+	return m
+}
+
+type testOutput struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+func (o *testOutput) Validate() error {
+	if o.Name == "" {
+		return errors.New(`"name" is empty`)
+	}
+	return nil
+}
+
+var testOutputSchema = &OutputSchema{
+	Name: "test_output",
+	Parameters: map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"name":  map[string]any{"type": "string"},
+			"count": map[string]any{"type": "integer"},
+		},
+	},
+	Validate: func(v any) error {
+		if v.(*testOutput).Count < 1 {
+			return errors.New(`"count" is less than 1`)
+		}
+		return nil
+	},
+}
+
+func messageText(m llms.MessageContent) string {
+	var b strings.Builder
+	for _, p := range m.Parts {
+		if t, ok := p.(llms.TextContent); ok {
+			b.WriteString(t.Text)
+		}
+	}
+	return b.String()
+}
+
+func TestStructuredOutputRepairsWithStage(t *testing.T) {
+	stage := scripted("```json\n{\"name\": \"\", \"count\": 1}\n```", `{"name": "go", "count": 1}`)
+	s := NewStructuredOutput(stage, &LLMConfig{Provider: "ollama"}, nil)
+
+	var out testOutput
+	if err := s.Generate(context.Background(), "prompt", testOutputSchema, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Name != "go" || out.Count != 1 {
+		t.Errorf("out = %+v", out)
+	}
+	if len(stage.calls) != 2 {
+		t.Fatalf("stage was called %d times, want 2", len(stage.calls))
+	}
+	// The stage continues the conversation with its own response and the reasons.
+	repair := stage.calls[1]
+	if len(repair) != 3 || repair[1].Role != llms.ChatMessageTypeAI || !strings.Contains(messageText(repair[2]), `"name" is empty`) {
+		t.Errorf("repair messages = %+v", repair)
+	}
+	if !stage.options[0].JSONMode || !strings.Contains(messageText(repair[0]), "JSON schema") {
+		t.Error("the schema is not put in the prompt with JSON mode")
+	}
+}
+
+func TestStructuredOutputRepairsWithRepairer(t *testing.T) {
+	stage := scripted(`{"name": "go", "count": 0}`)
+	repair := scripted(`{"name": "go", "count": 0, "extra": true}`, `{"name": "go", "count": 2}`)
+	s := NewStructuredOutput(stage, &LLMConfig{Provider: "openai"}, NewJSONRepairer(repair, &LLMConfig{Provider: "ollama"}))
+
+	var out testOutput
+	if err := s.Generate(context.Background(), "a long prompt with the articles", testOutputSchema, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Count != 2 {
+		t.Errorf("out = %+v", out)
+	}
+	if len(stage.calls) != 1 || len(repair.calls) != 2 {
+		t.Fatalf("calls: stage %d, repair %d, want 1 and 2", len(stage.calls), len(repair.calls))
+	}
+	if len(stage.options[0].Tools) != 1 || stage.options[0].Tools[0].Function.Name != testOutputSchema.Name {
+		t.Errorf("the stage is not given the schema as a tool: %+v", stage.options[0].Tools)
+	}
+	for i, reasons := range []string{`"count" is less than 1`, `unknown field "extra"`} {
+		prompt := messageText(repair.calls[i][0])
+		if len(repair.calls[i]) != 1 || strings.Contains(prompt, "articles") || !strings.Contains(prompt, reasons) || !strings.Contains(prompt, "JSON schema") {
+			t.Errorf("repair prompt %d = %q", i, prompt)
+		}
+	}
+}
+
+func TestStructuredOutputGivesUp(t *testing.T) {
+	stage := scripted("no json", "{}", "{}")
+	s := NewStructuredOutput(stage, &LLMConfig{Provider: "anthropic"}, nil)
+
+	var out testOutput
+	err := s.Generate(context.Background(), "prompt", testOutputSchema, &out)
+	if err == nil || !strings.Contains(err.Error(), "after 3 attempts") {
+		t.Errorf("err = %v", err)
+	}
+	if out != (testOutput{}) {
+		t.Errorf("out is set to an invalid output: %+v", out)
+	}
+}
+
+func TestStructuredOutputToolCall(t *testing.T) {
+	stage := &scriptedLLM{responses: []*llms.ContentChoice{{
+		ToolCalls: []llms.ToolCall{{FunctionCall: &llms.FunctionCall{Name: "test_output", Arguments: `{"name": "go", "count": 3}`}}},
+	}}}
+	s := NewStructuredOutput(stage, &LLMConfig{Provider: "openai"}, nil)
+
+	var out testOutput
+	if err := s.Generate(context.Background(), "prompt", testOutputSchema, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Count != 3 {
+		t.Errorf("out = %+v", out)
+	}
+	opts := stage.options[0]
+	if len(opts.Tools) != 1 || opts.Tools[0].Function.Name != "test_output" {
+		t.Errorf("tools = %+v", opts.Tools)
+	}
+	if choice, ok := opts.ToolChoice.(llms.ToolChoice); !ok || choice.Type != "function" || choice.Function == nil || choice.Function.Name != "test_output" {
+		t.Errorf("tool choice = %+v, want the tool forced", opts.ToolChoice)
+	}
+}
@@ -0,0 +1,253 @@
+package artisum
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type SummaryFieldType string
+
+const (
+	// SummaryFieldText is a few sentences.
+	SummaryFieldText SummaryFieldType = "text"
+	// SummaryFieldList is a list of short items such as keywords or action items.
+	SummaryFieldList SummaryFieldType = "list"
+	// SummaryFieldEnum is one of Options.
+	SummaryFieldEnum SummaryFieldType = "enum"
+	// SummaryFieldRating is an integer from 1 to Max.
+	SummaryFieldRating SummaryFieldType = "rating"
+)
+
+const defaultRatingMax = 5
+
+// SummaryField is an item of the summary. The LLM fills it according to its type and description.
+type SummaryField struct {
+	// Name is the key of the field in the LLM output. It must consist of letters, digits and underscores.
+	Name    string           `json:"name"`
+	Heading string           `json:"heading"`
+	Type    SummaryFieldType `json:"type"`
+	// Description tells the LLM what to write.
+	Description string   `json:"description,omitempty"`
+	Options     []string `json:"options,omitempty"`
+	Max         int      `json:"max,omitempty"`
+	// NotionProperty is the database property the value is also written to,
+	// as rich_text for text, multi_select for list, select for enum and number for rating.
+	NotionProperty string `json:"notionProperty,omitempty"`
+}
+
+type SummarySchema struct {
+	Fields []*SummaryField `json:"fields"`
+}
+
+// defaultSummaryFields are the six sections artisum has always written, headed in the prompt language.
+func defaultSummaryFields(language string) []*SummaryField {
+	fields := []*SummaryField{
+		{Name: "summary", Type: SummaryFieldText, Description: "Summary of the content"},
+		{Name: "key_points", Type: SummaryFieldList, Description: "Key points in the content"},
+		{Name: "background", Type: SummaryFieldText, Description: "Background and issues discussed in the article"},
+		{Name: "approaches", Type: SummaryFieldText, Description: "Approaches taken regarding the background and issues"},
+		{Name: "results", Type: SummaryFieldText, Description: "Results of the approaches"},
+		{Name: "keywords", Type: SummaryFieldList, Description: "Technical keywords, keeping each term as it is written in the content"},
+	}
+	headings := labelsFor(language).headings
+	for _, f := range fields {
+		f.Heading = headings[f.Name]
+	}
+	return fields
+}
+
+var summaryFieldNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func (s *SummarySchema) setDefaults(language string) {
+	if len(s.Fields) == 0 {
+		s.Fields = defaultSummaryFields(language)
+	}
+	for _, f := range s.Fields {
+		if f.Type == "" {
+			f.Type = SummaryFieldText
+		}
+		if f.Heading == "" {
+			f.Heading = f.Name
+		}
+		if f.Type == SummaryFieldRating && f.Max <= 0 {
+			f.Max = defaultRatingMax
+		}
+	}
+}
+
+func (s *SummarySchema) validate() error {
+	var errs []error
+	names := make(map[string]bool, len(s.Fields))
+	for i, f := range s.Fields {
+		if !summaryFieldNameRegexp.MatchString(f.Name) {
+			errs = append(errs, fmt.Errorf("summary field %d: invalid name %q", i, f.Name))
+		}
+		if names[f.Name] {
+			errs = append(errs, fmt.Errorf("summary field %q is defined twice", f.Name))
+		}
+		names[f.Name] = true
+		switch f.Type {
+		case SummaryFieldText, SummaryFieldList, SummaryFieldRating:
+		case SummaryFieldEnum:
+			if len(f.Options) == 0 {
+				errs = append(errs, fmt.Errorf("summary field %q: enum needs options", f.Name))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("summary field %q: unknown type %q", f.Name, f.Type))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// promptDescription lists the fields for prompts, one line per field.
+func (s *SummarySchema) promptDescription() string {
+	var b strings.Builder
+	for i, f := range s.Fields {
+		fmt.Fprintf(&b, "%d. %q (%s): %s\n", i+1, f.Name, f.valueDescription(), firstNonEmpty(f.Description, f.Heading))
+	}
+	return b.String()
+}
+
+func (f *SummaryField) valueDescription() string {
+	switch f.Type {
+	case SummaryFieldList:
+		return "an array of short items"
+	case SummaryFieldEnum:
+		quoted := make([]string, len(f.Options))
+		for i, o := range f.Options {
+			quoted[i] = strconv.Quote(o)
+		}
+		return "one of " + strings.Join(quoted, ", ")
+	case SummaryFieldRating:
+		return fmt.Sprintf("an integer from 1 to %d", f.Max)
+	default:
+		return "an array of sentences"
+	}
+}
+
+func (s *SummarySchema) outputSchema() *OutputSchema {
+	properties := make(map[string]any, len(s.Fields))
+	required := make([]string, 0, len(s.Fields))
+	for _, f := range s.Fields {
+		properties[f.Name] = f.jsonSchema()
+		required = append(required, f.Name)
+	}
+	return &OutputSchema{
+		Name:        "summary",
+		Description: "Report the summary of the article, one property per item.",
+		Parameters: map[string]any{
+			"type":                 "object",
+			"properties":           properties,
+			"required":             required,
+			"additionalProperties": false,
+		},
+		Validate: func(v any) error {
+			_, err := s.contents(*v.(*map[string]json.RawMessage))
+			return err
+		},
+	}
+}
+
+func (f *SummaryField) jsonSchema() map[string]any {
+	schema := map[string]any{"description": firstNonEmpty(f.Description, f.Heading)}
+	switch f.Type {
+	case SummaryFieldEnum:
+		schema["type"] = "string"
+		schema["enum"] = f.Options
+	case SummaryFieldRating:
+		schema["type"] = "integer"
+		schema["minimum"] = 1
+		schema["maximum"] = f.Max
+	default:
+		schema["type"] = "array"
+		schema["items"] = map[string]any{"type": "string"}
+	}
+	return schema
+}
+
+// contents converts the LLM output into FormatContents in the order of the fields.
+func (s *SummarySchema) contents(values map[string]json.RawMessage) ([]*FormatContent, error) {
+	var errs []error
+	for name := range values {
+		if s.field(name) == nil {
+			errs = append(errs, fmt.Errorf("%q is not an item of the summary", name))
+		}
+	}
+
+	contents := make([]*FormatContent, 0, len(s.Fields))
+	for _, f := range s.Fields {
+		raw, ok := values[f.Name]
+		if !ok {
+			errs = append(errs, fmt.Errorf("%q is missing", f.Name))
+			continue
+		}
+		content, err := f.content(raw)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%q: %w", f.Name, err))
+			continue
+		}
+		contents = append(contents, content)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return contents, nil
+}
+
+func (f *SummaryField) content(raw json.RawMessage) (*FormatContent, error) {
+	content := &FormatContent{Heading: f.Heading, Field: f.Name, Type: f.Type}
+	switch f.Type {
+	case SummaryFieldEnum:
+		var option string
+		if err := json.Unmarshal(raw, &option); err != nil {
+			return nil, fmt.Errorf("must be a string: %w", err)
+		}
+		for _, o := range f.Options {
+			if strings.EqualFold(o, strings.TrimSpace(option)) {
+				content.Option = o
+				content.Sentences = []string{o}
+				return content, nil
+			}
+		}
+		return nil, fmt.Errorf("%q is not one of %s", option, strings.Join(f.Options, ", "))
+	case SummaryFieldRating:
+		var rating float64
+		if err := json.Unmarshal(raw, &rating); err != nil {
+			return nil, fmt.Errorf("must be a number: %w", err)
+		}
+		if rating != float64(int(rating)) || rating < 1 || int(rating) > f.Max {
+			return nil, fmt.Errorf("%v is not an integer from 1 to %d", rating, f.Max)
+		}
+		content.Rating = &rating
+		content.Sentences = []string{fmt.Sprintf("%d/%d", int(rating), f.Max)}
+		return content, nil
+	default:
+		var sentences []string
+		if err := json.Unmarshal(raw, &sentences); err != nil {
+			// A single sentence is accepted as is.
+			var sentence string
+			if json.Unmarshal(raw, &sentence) != nil {
+				return nil, errors.New("must be an array of strings")
+			}
+			sentences = []string{sentence}
+		}
+		if sentences == nil {
+			sentences = []string{}
+		}
+		content.Sentences = sentences
+		return content, nil
+	}
+}
+
+func (s *SummarySchema) field(name string) *SummaryField {
+	for _, f := range s.Fields {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package artisum
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestSummarySchemaDefaults(t *testing.T) {
+	tests := []struct {
+		language string
+		want     string
+	}{
+		{"ja", "概要"},
+		{"en", "Summary"},
+		{"", "概要"},
+		{"fr", "Summary"},
+	}
+	for _, tt := range tests {
+		s := &SummarySchema{}
+		s.setDefaults(tt.language)
+		if len(s.Fields) != 6 || s.Fields[0].Heading != tt.want {
+			t.Errorf("%q: fields[0] = %+v", tt.language, s.Fields[0])
+		}
+		for _, f := range s.Fields {
+			if f.Heading == "" {
+				t.Errorf("%q: %s has no heading", tt.language, f.Name)
+			}
+		}
+	}
+
+	// The defaults are not shared, so that a config can not change the ones of another.
+	a, b := &SummarySchema{}, &SummarySchema{}
+	a.setDefaults("ja")
+	b.setDefaults("ja")
+	a.Fields[0].Heading = "changed"
+	if b.Fields[0].Heading != "概要" {
+		t.Error("default fields are shared")
+	}
+
+	s := &SummarySchema{Fields: []*SummaryField{{Name: "score", Type: SummaryFieldRating}, {Name: "note"}}}
+	s.setDefaults("en")
+	if s.Fields[0].Max != defaultRatingMax || s.Fields[0].Heading != "score" || s.Fields[1].Type != SummaryFieldText {
+		t.Errorf("fields = %+v, %+v", s.Fields[0], s.Fields[1])
+	}
+}
+
+func TestSummarySchemaValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields []*SummaryField
+		want   []string
+	}{
+		{
+			name:   "valid",
+			fields: []*SummaryField{{Name: "summary", Type: SummaryFieldText}, {Name: "level", Type: SummaryFieldEnum, Options: []string{"beginner"}}},
+		},
+		{
+			name:   "invalid name",
+			fields: []*SummaryField{{Name: "key points", Type: SummaryFieldText}},
+			want:   []string{`invalid name "key points"`},
+		},
+		{
+			name:   "defined twice",
+			fields: []*SummaryField{{Name: "summary", Type: SummaryFieldText}, {Name: "summary", Type: SummaryFieldList}},
+			want:   []string{`"summary" is defined twice`},
+		},
+		{
+			name:   "enum without options and unknown type",
+			fields: []*SummaryField{{Name: "level", Type: SummaryFieldEnum}, {Name: "when", Type: "date"}},
+			want:   []string{"enum needs options", `unknown type "date"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := (&SummarySchema{Fields: tt.fields}).validate()
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Errorf("err = %v", err)
+				}
+				return
+			}
+			for _, want := range tt.want {
+				if err == nil || !strings.Contains(err.Error(), want) {
+					t.Errorf("err = %v, want %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSummarySchemaContents(t *testing.T) {
+	s := &SummarySchema{Fields: []*SummaryField{
+		{Name: "summary", Heading: "Summary", Type: SummaryFieldText},
+		{Name: "keywords", Heading: "Keywords", Type: SummaryFieldList},
+		{Name: "level", Heading: "Level", Type: SummaryFieldEnum, Options: []string{"Beginner", "Advanced"}},
+		{Name: "score", Heading: "Score", Type: SummaryFieldRating, Max: 5},
+	}}
+	decode := func(raw string) map[string]json.RawMessage {
+		var values map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(raw), &values); err != nil {
+			t.Fatal(err)
+		}
+		return values
+	}
+
+	contents, err := s.contents(decode(`{"summary": "One sentence.", "keywords": [], "level": " advanced", "score": 4}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(contents) != 4 {
+		t.Fatalf("contents = %d", len(contents))
+	}
+	if got := contents[0]; got.Heading != "Summary" || got.Field != "summary" || len(got.Sentences) != 1 {
+		t.Errorf("text = %+v", got)
+	}
+	if got := contents[1]; got.Sentences == nil || len(got.Sentences) != 0 {
+		t.Errorf("list = %+v", got)
+	}
+	if got := contents[2]; got.Option != "Advanced" || got.Sentences[0] != "Advanced" {
+		t.Errorf("enum = %+v", got)
+	}
+	if got := contents[3]; got.Rating == nil || *got.Rating != 4 || got.Sentences[0] != "4/5" {
+		t.Errorf("rating = %+v", got)
+	}
+
+	_, err = s.contents(decode(`{"summary": 1, "level": "expert", "score": 4.5, "extra": []}`))
+	for _, want := range []string{
+		`"extra" is not an item of the summary`,
+		`"summary": must be an array of strings`,
+		`"keywords" is missing`,
+		`"level": "expert" is not one of Beginner, Advanced`,
+		`"score": 4.5 is not an integer from 1 to 5`,
+	} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("err = %v, want %q", err, want)
+		}
+	}
+}
+
+func TestSummarySchemaOutputSchema(t *testing.T) {
+	s := &SummarySchema{Fields: []*SummaryField{{Name: "summary", Type: SummaryFieldText}, {Name: "score", Type: SummaryFieldRating, Max: 3}}}
+	schema := s.outputSchema()
+	properties := schema.Parameters["properties"].(map[string]any)
+	if got := properties["score"].(map[string]any); got["type"] != "integer" || got["maximum"] != 3 {
+		t.Errorf("score = %v", got)
+	}
+	values := map[string]json.RawMessage{"summary": json.RawMessage(`["a"]`)}
+	if err := schema.Validate(&values); err == nil || !strings.Contains(err.Error(), `"score" is missing`) {
+		t.Errorf("err = %v", err)
+	}
+}
@@ -0,0 +1,54 @@
+(function () {
+  var input = document.getElementById("search");
+  var results = document.getElementById("search-results");
+  if (!input || !results) {
+    return;
+  }
+
+  var index = null;
+  function load() {
+    if (index) {
+      return Promise.resolve(index);
+    }
+    return fetch("search.json")
+      .then(function (resp) { return resp.json(); })
+      .then(function (entries) {
+        index = entries.map(function (e) {
+          e.haystack = [e.title, e.tag, e.text].join(" ").toLowerCase();
+          return e;
+        });
+        return index;
+      });
+  }
+
+  function render(entries) {
+    results.textContent = "";
+    entries.slice(0, 50).forEach(function (e) {
+      var li = document.createElement("li");
+      var date = document.createElement("span");
+      date.className = "date";
+      date.textContent = e.date + " ";
+      var link = document.createElement("a");
+      link.href = e.path;
+      link.textContent = e.title;
+      var tag = document.createElement("span");
+      tag.className = "tag";
+      tag.textContent = e.tag;
+      li.append(date, link, " ", tag);
+      results.appendChild(li);
+    });
+  }
+
+  input.addEventListener("input", function () {
+    var terms = input.value.toLowerCase().split(/\s+/).filter(Boolean);
+    if (terms.length === 0) {
+      results.textContent = "";
+      return;
+    }
+    load().then(function (entries) {
+      render(entries.filter(function (e) {
+        return terms.every(function (t) { return e.haystack.indexOf(t) >= 0; });
+      }));
+    });
+  });
+})();
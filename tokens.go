@@ -0,0 +1,55 @@
+package artisum
+
+import (
+	"sync"
+	"unicode/utf8"
+
+	"github.com/pkoukk/tiktoken-go"
+)
+
+const fallbackEncoding = "cl100k_base"
+
+var (
+	tokenizersMu sync.Mutex
+	// tokenizers caches the encoder per model because loading one parses the whole BPE file.
+	// A nil value means no encoder is available, e.g. the BPE file can not be downloaded.
+	tokenizers = make(map[string]*tiktoken.Tiktoken)
+)
+
+// countTokens counts the tokens of text for model. Models without a tiktoken encoding, e.g. Claude or Gemini,
+// are counted with cl100k_base, and an approximation is used when no encoder can be loaded.
+func countTokens(model, text string) int {
+	if t := tokenizer(model); t != nil {
+		return len(t.Encode(text, nil, nil))
+	}
+	return approximateTokens(text)
+}
+
+func tokenizer(model string) *tiktoken.Tiktoken {
+	tokenizersMu.Lock()
+	defer tokenizersMu.Unlock()
+
+	if t, ok := tokenizers[model]; ok {
+		return t
+	}
+	t, err := tiktoken.EncodingForModel(model)
+	if err != nil {
+		t, err = tiktoken.GetEncoding(fallbackEncoding)
+	}
+	if err != nil {
+		t = nil
+	}
+	tokenizers[model] = t
+	return t
+}
+
+// approximateTokens assumes 4 characters per token for ASCII and 1 for other characters such as Japanese.
+func approximateTokens(text string) int {
+	ascii := 0
+	for i := 0; i < len(text); i++ {
+		if text[i] < utf8.RuneSelf {
+			ascii++
+		}
+	}
+	return (ascii+3)/4 + utf8.RuneCountInString(text) - ascii
+}
@@ -0,0 +1,21 @@
+package artisum
+
+import "testing"
+
+func TestApproximateTokens(t *testing.T) {
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"", 0},
+		{"go", 1},
+		{"generics", 2},
+		{"記事要約", 4},
+		{"Go の記事", 4},
+	}
+	for _, tt := range tests {
+		if got := approximateTokens(tt.text); got != tt.want {
+			t.Errorf("approximateTokens(%q) = %d, want %d", tt.text, got, tt.want)
+		}
+	}
+}